@@ -0,0 +1,184 @@
+package rain
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// basicAuth 使用固定的用户名和密码
+type basicAuth struct {
+	username string
+	password string
+}
+
+// Sign 设置 Basic 认证
+func (a *basicAuth) Sign(req *http.Request, refresh bool) error {
+	req.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+// bearerToken 从 source 获取 Bearer Token，请求返回 401 或 403 时重新获取
+type bearerToken struct {
+	mux    sync.Mutex
+	source func(ctx context.Context) (string, error)
+	token  string
+}
+
+// Sign 设置 Bearer 认证
+func (b *bearerToken) Sign(req *http.Request, refresh bool) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	// 其他请求已经获取了新的 token 时不需要重复获取
+	if b.token == "" || (refresh && req.Header.Get("authorization") == "Bearer "+b.token) {
+		token, err := b.source(req.Context())
+		if err != nil {
+			return err
+		}
+		b.token = token
+	}
+	req.Header.Set("authorization", "Bearer "+b.token)
+	return nil
+}
+
+// netrcEntry .netrc 中的一条认证信息
+type netrcEntry struct {
+	machine  string
+	login    string
+	password string
+}
+
+// netrcAuth 根据请求的主机名使用 .netrc 中的认证信息
+type netrcAuth struct {
+	once    sync.Once
+	path    string
+	entries []netrcEntry
+	err     error
+}
+
+// Sign 设置 .netrc 中对应主机的 Basic 认证，没有对应的主机时不做修改
+func (n *netrcAuth) Sign(req *http.Request, refresh bool) error {
+	n.once.Do(n.load)
+	if n.err != nil {
+		return n.err
+	}
+	// 已经设置了认证信息时不覆盖
+	if req.Header.Get("authorization") != "" && !refresh {
+		return nil
+	}
+	if entry := lookupNetrc(n.entries, req.URL.Hostname()); entry != nil {
+		req.SetBasicAuth(entry.login, entry.password)
+	}
+	return nil
+}
+
+// load 加载 .netrc，未指定路径时使用 NETRC 环境变量或者用户目录下的 .netrc，默认文件不存在时忽略
+func (n *netrcAuth) load() {
+	path := n.path
+	if path == "" {
+		path = defaultNetrcPath()
+		if !fileExist(path) {
+			return
+		}
+	}
+	d, err := os.ReadFile(path)
+	if err != nil {
+		n.err = err
+		return
+	}
+	n.entries, n.err = parseNetrc(d)
+}
+
+// defaultNetrcPath 默认的 .netrc 路径
+func defaultNetrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// parseNetrc 解析 .netrc，default 的 machine 为空
+func parseNetrc(d []byte) ([]netrcEntry, error) {
+	var (
+		entries []netrcEntry
+		entry   *netrcEntry
+		macdef  bool
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(d))
+	for scanner.Scan() {
+		line := scanner.Text()
+		// macdef 的内容到空行结束
+		if macdef {
+			macdef = strings.TrimSpace(line) != ""
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			switch fields[i] {
+			case "machine", "default":
+				if entry != nil {
+					entries = append(entries, *entry)
+				}
+				entry = &netrcEntry{}
+				if fields[i] == "machine" {
+					if i+1 >= len(fields) {
+						return nil, errors.New("netrc: missing machine name")
+					}
+					i++
+					entry.machine = fields[i]
+				}
+			case "login", "password", "account":
+				if entry == nil || i+1 >= len(fields) {
+					return nil, errors.New("netrc: unexpected " + fields[i])
+				}
+				i++
+				if fields[i-1] == "login" {
+					entry.login = fields[i]
+				} else if fields[i-1] == "password" {
+					entry.password = fields[i]
+				}
+			case "macdef":
+				macdef = true
+				i = len(fields)
+			}
+		}
+	}
+	if entry != nil {
+		entries = append(entries, *entry)
+	}
+	return entries, scanner.Err()
+}
+
+// lookupNetrc 查找主机对应的认证信息，没有时使用 default
+func lookupNetrc(entries []netrcEntry, host string) *netrcEntry {
+	var def *netrcEntry
+	for i, v := range entries {
+		if v.machine == "" {
+			if def == nil {
+				def = &entries[i]
+			}
+			continue
+		}
+		if strings.EqualFold(v.machine, host) {
+			return &entries[i]
+		}
+	}
+	return def
+}
@@ -0,0 +1,52 @@
+package rain
+
+import (
+	"testing"
+)
+
+func TestParseNetrc(t *testing.T) {
+	d := []byte(`# comment
+machine example.com login alice password secret
+machine files.example.com
+	login bob
+	password "pw"
+	account ignored
+macdef init
+	cd /pub
+	binary
+
+default login anonymous password guest
+`)
+	entries, err := parseNetrc(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []struct {
+		host     string
+		login    string
+		password string
+	}{
+		{"example.com", "alice", "secret"},
+		{"FILES.example.com", "bob", `"pw"`},
+		{"other.com", "anonymous", "guest"},
+	}
+	for _, v := range data {
+		entry := lookupNetrc(entries, v.host)
+		if entry == nil || entry.login != v.login || entry.password != v.password {
+			t.Fatal(v.host, entry)
+		}
+	}
+
+	entries, err = parseNetrc([]byte("machine example.com login alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lookupNetrc(entries, "other.com") != nil {
+		t.Fatal("没有 default 时应该返回 nil")
+	}
+
+	_, err = parseNetrc([]byte("login alice"))
+	if err == nil {
+		t.Fatal("缺少 machine 时应该返回错误")
+	}
+}
@@ -2,7 +2,9 @@ package rain
 
 import (
 	"encoding/json"
+	"mime"
 	"os"
+	"strings"
 )
 
 // breakpoint 断点
@@ -11,6 +13,12 @@ type Breakpoint struct {
 	Filesize int64 `json:"filesize"`
 	// etag 资源唯一标识
 	Etag string `json:"etag"`
+	// LastModified 资源最后修改时间
+	LastModified string `json:"last_modified"`
+	// ContentType 资源类型
+	ContentType string `json:"content_type"`
+	// FinalURI 跳转后的链接，续传时下载块直接请求该链接，不参与资源对比
+	FinalURI string `json:"final_uri"`
 	// position 未分配任务的起始位置
 	Position int64 `json:"position"`
 	// tasks 已分配的未完成任务
@@ -48,12 +56,21 @@ func (bp *Breakpoint) completedSize() int64 {
 	return cl
 }
 
-// comparison 对比是否是相同资源
+// comparison 对比是否是相同资源，资源类型只对比类型不对比参数
+// 旧格式的断点文件没有记录最后修改时间和资源类型，为空时不对比
 func (bp *Breakpoint) comparison(loadbp *Breakpoint) bool {
-	if bp.Etag == loadbp.Etag && bp.Filesize == loadbp.Filesize {
-		return true
+	return bp.Etag == loadbp.Etag &&
+		bp.Filesize == loadbp.Filesize &&
+		(loadbp.LastModified == "" || bp.LastModified == loadbp.LastModified) &&
+		(loadbp.ContentType == "" || mediaType(bp.ContentType) == mediaType(loadbp.ContentType))
+}
+
+// mediaType 获取 Content-Type 中的资源类型，忽略 charset 等参数
+func mediaType(contentType string) string {
+	if v, _, err := mime.ParseMediaType(contentType); err == nil {
+		return v
 	}
-	return false
+	return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
 }
 
 // export 导出
@@ -63,10 +80,13 @@ func (bp *Breakpoint) export(path string, perm os.FileMode) error {
 	}
 	tasks := bp.Tasks
 	tmp := &Breakpoint{
-		Filesize: bp.Filesize,
-		Etag:     bp.Etag,
-		Position: tasks[len(tasks)-1].End + 1,
-		Tasks:    make([]*Block, 0),
+		Filesize:     bp.Filesize,
+		Etag:         bp.Etag,
+		LastModified: bp.LastModified,
+		ContentType:  bp.ContentType,
+		FinalURI:     bp.FinalURI,
+		Position:     tasks[len(tasks)-1].End + 1,
+		Tasks:        make([]*Block, 0),
 	}
 	for _, v := range tasks {
 		if v.isFinish() {
@@ -0,0 +1,38 @@
+package rain
+
+import "testing"
+
+// TestBreakpointComparison 测试断点的资源对比
+func TestBreakpointComparison(t *testing.T) {
+	bp := &Breakpoint{Filesize: 1024, Etag: `"rain"`, ContentType: "application/octet-stream"}
+	testData := []struct {
+		contentType string
+		same        bool
+	}{
+		{"application/octet-stream", true},
+		{"application/octet-stream; charset=binary", true},
+		{"Application/Octet-Stream", true},
+		{"application/octet-stream;", true},
+		{"video/mp4", false},
+	}
+	for _, v := range testData {
+		loadbp := &Breakpoint{Filesize: 1024, Etag: `"rain"`, ContentType: v.contentType}
+		if bp.comparison(loadbp) != v.same {
+			t.Errorf("%s 对比结果错误", v.contentType)
+		}
+	}
+}
+
+// TestBreakpointComparisonOldFormat 测试旧格式的断点文件没有记录最后修改时间和资源类型
+func TestBreakpointComparisonOldFormat(t *testing.T) {
+	bp := &Breakpoint{Filesize: 1024, Etag: `"rain"`, LastModified: "Wed, 21 Oct 2015 07:28:00 GMT", ContentType: "video/mp4"}
+	if !bp.comparison(&Breakpoint{Filesize: 1024, Etag: `"rain"`}) {
+		t.Fatal("旧格式的断点文件应该可以续传")
+	}
+	if bp.comparison(&Breakpoint{Filesize: 1024, Etag: `"rain"`, LastModified: "Thu, 22 Oct 2015 07:28:00 GMT"}) {
+		t.Fatal("最后修改时间不同时不应该续传")
+	}
+	if bp.comparison(&Breakpoint{Filesize: 2048, Etag: `"rain"`}) {
+		t.Fatal("资源大小不同时不应该续传")
+	}
+}
@@ -8,48 +8,103 @@ import (
 type Config struct {
 	// RoutineCount 多协程下载时最多同时下载一个文件的最大协程，默认为 1
 	RoutineCount int
+	// RoutineAuto 根据下载速度自动调整协程数量，RoutineCount 为协程数量上限，默认为 false
+	RoutineAuto bool
 	// RoutineSize 多协程下载时每个协程下载的大小，默认为 10M
 	RoutineSize int64
 	// diskCache 磁盘缓冲区大小，默认为 1M
 	DiskCache int
 	// speedLimit 下载速度限制，默认为 0 无限制
 	SpeedLimit int
+	// SpeedBurst 限速器允许的突发字节数，默认为 0 与 SpeedLimit 相同
+	SpeedBurst int
+	// SpeedSchedule 分时段限速计划，设置后会随时间自动调整 SpeedLimit，默认为 nil
+	SpeedSchedule *SpeedSchedule
+	// LowSpeedLimit 每秒最低下载字节数，下载块在 LowSpeedTime 内持续低于该速度时重试，默认为 0 不检测
+	LowSpeedLimit int
+	// LowSpeedTime 低速检测的时间窗口，收到响应头之后开始计算，默认为 0 不检测
+	LowSpeedTime time.Duration
+	// MaxRedirects 最多跳转次数，默认为 10
+	MaxRedirects int
+	// Protocol 下载使用的 HTTP 协议，默认为 PROTOCOL_AUTO
+	Protocol Protocol
+	// ProbeHead 探测资源时先尝试 HEAD 请求，失败时使用 range 请求，默认为 false
+	ProbeHead bool
 	// createDir 当需要创建目录时，是否创建目录，默认为 true
 	CreateDir bool
 	// allowOverwrite 是否允许覆盖文件，默认为 false
 	AllowOverwrite bool
 	// autoFileRenaming 文件自动重命名，新文件名在名称之后扩展名之前加上一个点和一个数字（1..9999）。默认:true
 	AutoFileRenaming bool
+	// RenameStrategy 自动重命名的规则，默认为 nil 使用 RenameDotNumber
+	RenameStrategy RenameStrategy
+	// RenameMaxAttempts 自动重命名的最多次数，超过后返回 *RenameError，默认为 9999
+	RenameMaxAttempts int
 	// AutoFilterFilename 自动过滤掉文件名称中的非法字符
 	AutoFilterFilename bool
+	// FilenamePolicy 过滤文件名称时使用的规则，默认为 FILENAME_LEGACY
+	FilenamePolicy FilenamePolicy
+	// FilenameReplacement 替换文件名称中非法字符的字符串，FILENAME_LEGACY 直接删除非法字符，默认为 _
+	FilenameReplacement string
 	// breakpointResume 是否启用断点续传，默认为 true
 	BreakpointResume bool
-	// timeout 下载总超时时间，默认为 10 分钟
+	// timeout 下载总超时时间，暂停的时间不计入，默认为 0 不限制
 	Timeout time.Duration
+	// ConnectTimeout 建立连接的超时时间，默认为 30 秒
+	ConnectTimeout time.Duration
+	// ResponseHeaderTimeout 建立连接后等待响应头的超时时间，默认为 30 秒
+	ResponseHeaderTimeout time.Duration
+	// IdleTimeout 读取数据时的空闲超时时间，超过该时间没有收到数据时重试下载块，默认为 60 秒
+	IdleTimeout time.Duration
 	// retryNumber 最多重试次数，默认为 5
 	RetryNumber int
 	// retryTime 重试时的间隔时间，默认为 0
 	RetryTime time.Duration
 	// BreakpointExt 断点文件扩展, 默认为 .temp.rain
 	BreakpointExt string
+	// Decompress 请求压缩传输并将内容解码后写入文件，只使用单协程下载，默认为 false
+	Decompress bool
+	// SyncMode 同步模式，输出文件已经存在时只在资源发生变化时重新下载并覆盖，默认为 false
+	SyncMode bool
+	// MetaExt 同步模式下保存资源信息的文件扩展, 默认为 .meta.rain
+	MetaExt string
+	// OutTemplate 输出路径模板，没有设置输出文件名时根据资源信息计算相对于输出目录的路径，默认为空
+	OutTemplate string
 }
 
 // NewConfig 创建默认配置
 func NewConfig() *Config {
 	return &Config{
-		RoutineCount:       1,
-		RoutineSize:        1048576 * 10,
-		DiskCache:          1048576 * 1,
-		SpeedLimit:         0,
-		CreateDir:          true,
-		AllowOverwrite:     false,
-		BreakpointResume:   true,
-		AutoFileRenaming:   true,
-		AutoFilterFilename: true,
-		Timeout:            time.Minute * 10,
-		RetryNumber:        5,
-		RetryTime:          0,
-		BreakpointExt:      ".temp.rain",
+		RoutineCount:          1,
+		RoutineSize:           1048576 * 10,
+		DiskCache:             1048576 * 1,
+		SpeedLimit:            0,
+		SpeedBurst:            0,
+		SpeedSchedule:         nil,
+		LowSpeedLimit:         0,
+		LowSpeedTime:          0,
+		CreateDir:             true,
+		AllowOverwrite:        false,
+		BreakpointResume:      true,
+		AutoFileRenaming:      true,
+		RenameStrategy:        nil,
+		RenameMaxAttempts:     9999,
+		AutoFilterFilename:    true,
+		FilenamePolicy:        FILENAME_LEGACY,
+		FilenameReplacement:   "_",
+		Timeout:               0,
+		ConnectTimeout:        time.Second * 30,
+		ResponseHeaderTimeout: time.Second * 30,
+		IdleTimeout:           time.Second * 60,
+		MaxRedirects:          10,
+		Protocol:              PROTOCOL_AUTO,
+		RetryNumber:           5,
+		RetryTime:             0,
+		BreakpointExt:         ".temp.rain",
+		Decompress:            false,
+		SyncMode:              false,
+		MetaExt:               ".meta.rain",
+		OutTemplate:           "",
 	}
 }
 
@@ -3,11 +3,15 @@ package rain
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io/fs"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/time/rate"
 )
@@ -41,18 +45,28 @@ type control struct {
 
 	// status 运行状态
 	status Status
-	// totalSize 资源大小
+	// totalSize 资源大小，未知大小的资源下载完成后才确定，使用 getTotalSize 和 setTotalSize 读写
 	totalSize int64
 	// completedSize 已下载大小
 	completedSize *int64
+	// transferSize 已接收的传输字节数，解压时为解码前的大小
+	transferSize *int64
+	// proto 最近一次下载请求使用的协议
+	proto atomic.Value
 	// threadCount 协程数量
 	threadCount int
+	// routine 自动调整协程数量时，限制同时下载的协程数量
+	routine *routineGate
 	// breakpointResume 是否可以断点续传
 	breakpointResume bool
 	// multithread 是否支持多线程
 	multithread bool
+	// resourceInfo 资源探测信息
+	resourceInfo *ResourceInfo
 	// outfile 文件指针
 	outfile *os.File
+	// claimedOutpath 当前下载占用的输出路径，同一个进程中的其他下载不能使用
+	claimedOutpath string
 	// breakpoint 断点
 	breakpoint *Breakpoint
 	// event 进度事件
@@ -61,10 +75,42 @@ type control struct {
 	eventExend []ProgressEventExtend
 	// sendEvent 事件发送
 	sendEvent func()
+	// cookieFile Netscape cookies.txt 格式的 cookie 文件
+	cookieFile string
+	// cookieSave 下载结束后是否将更新的 cookie 保存到 cookieFile
+	cookieSave bool
+	// cookieJar 从 cookieFile 加载的 cookie jar
+	cookieJar *CookieJar
+	// tls 当前下载的 TLS 设置，开始下载时应用到复制的 transport
+	tls *tlsSettings
+	// transport 当前下载复制的 transport，下载结束后关闭闲置连接
+	transport *http.Transport
+	// redirectPolicies 跳转策略
+	redirectPolicies []RedirectPolicy
+	// dial 当前下载建立连接的设置
+	dial *dialSettings
+	// proxies 当前下载使用的代理链接，多个代理时每个请求轮流使用
+	proxies []string
+	// hooks 下载生命周期钩子
+	hooks []Hook
+	// postProcessors 下载完成后的处理步骤
+	postProcessors []PostProcessor
+	// processCompleted 当前处理步骤已完成的数量
+	processCompleted int64
+	// processTotal 当前处理步骤的总数量
+	processTotal int64
 	// rate 限速器
 	rate *rate.Limiter
+	// scheduleChange 限速计划变更通知
+	scheduleChange chan struct{}
+	// scheduleDone 本次运行的限速计划 goroutine 结束通知
+	scheduleDone chan struct{}
 	// isclose 是否执行了 close
 	isclose bool
+	// runStart 本次运行的开始时间
+	runStart time.Time
+	// elapsed 之前运行累计的时间，不包含暂停的时间
+	elapsed time.Duration
 
 	// mux 锁
 	mux sync.Mutex
@@ -77,11 +123,11 @@ type control struct {
 // start 开始下载
 func (ctl *control) start(ctx context.Context) (err error) {
 	// 已经下载完成
-	if ctl.status.Is(STATUS_FINISH) {
+	if ctl.status.Is(STATUS_FINISH, STATUS_UNCHANGED) {
 		return errors.New("status is finish")
 	}
 	// 已经在运行中
-	if ctl.status.Is(STATUS_BEGIN, STATUS_RUNNING) {
+	if ctl.status.Is(STATUS_BEGIN, STATUS_RUNNING, STATUS_PROCESSING) {
 		return errors.New("status is rinning")
 	}
 	// 启动已经关闭的下载
@@ -102,7 +148,13 @@ func (ctl *control) start(ctx context.Context) (err error) {
 	ctl.log("new download: ", ctl.uri)
 
 	err = ctl.Init(ctx)
+	if errors.Is(err, errUnchanged) {
+		ctl.closeIdleConnections()
+		ctl.unchanged()
+		return nil
+	}
 	if err != nil {
+		ctl.closeIdleConnections()
 		ctl.setStatus(STATUS_NOTSTART)
 		return err
 	}
@@ -117,9 +169,13 @@ func (ctl *control) reuse(ctx context.Context) (err error) {
 	ctl.isclose = false
 	ctl.err = nil
 
-	// 打开文件
+	// 打开文件，其他下载已经使用了相同的文件时不能继续
+	if !ctl.claimOutpath(ctl.outpath) {
+		return os.ErrExist
+	}
 	ctl.outfile, err = os.OpenFile(ctl.outpath, os.O_CREATE|os.O_WRONLY, ctl.perm)
 	if err != nil {
+		ctl.releaseOutpath()
 		return err
 	}
 
@@ -131,14 +187,19 @@ func (ctl *control) reuse(ctx context.Context) (err error) {
 	return nil
 }
 
-// packContext 包装上下文
+// packContext 包装上下文，总超时时间扣除之前已经运行的时间
 func (ctl *control) packContext(ctx context.Context) {
 	if ctl.config.Timeout > 0 {
-		ctl.ctx, ctl.cancel = context.WithTimeout(ctx, ctl.config.Timeout)
+		ctl.ctx, ctl.cancel = context.WithTimeout(ctx, ctl.config.Timeout-ctl.elapsed)
 	} else {
 		ctl.ctx, ctl.cancel = context.WithCancel(ctx)
 	}
+	ctl.runStart = time.Now()
 	ctl.request.ctx = ctl.ctx
+	ctl.request.retryNumber = ctl.config.RetryNumber
+	ctl.request.retryTime = ctl.config.RetryTime
+	ctl.request.connectTimeout = ctl.config.ConnectTimeout
+	ctl.request.responseHeaderTimeout = ctl.config.ResponseHeaderTimeout
 }
 
 // Init 初始化
@@ -146,28 +207,57 @@ func (ctl *control) Init(ctx context.Context) error {
 	// 包装上下文
 	ctl.packContext(ctx)
 
+	// 请求客户端
+	err := ctl.prepareClient()
+	if err != nil {
+		return err
+	}
+
+	// 探测资源之前的钩子
+	err = ctl.hookBeforeProbe()
+	if err != nil {
+		return err
+	}
+
+	// 同步模式下已知输出文件时，携带条件请求头探测资源
+	meta := ctl.loadSyncMeta()
+	if meta != nil {
+		ctl.request.ifNoneMatch = meta.Etag
+		ctl.request.ifModifiedSince = meta.LastModified
+	}
+
 	// 资源基本信息
-	resInfo, err := ctl.request.getResourceInfo()
+	resInfo, err := ctl.request.getResourceInfo(ctl.config.ProbeHead)
+	ctl.request.ifNoneMatch, ctl.request.ifModifiedSince = "", ""
 	if err != nil {
 		return err
 	}
 	ctl.logf("resourceInfo: %#v\n", resInfo)
+	unchanged := resInfo.StatusCode == http.StatusNotModified
+	if unchanged {
+		ctl.resourceInfo = resInfo
+	} else {
+		ctl.setResourceInfo(resInfo)
+	}
 
-	// 断点信息
-	ctl.breakpoint = &Breakpoint{
-		Filesize: resInfo.filesize,
-		Etag:     resInfo.etag,
-		Position: 0,
-		Tasks:    make([]*Block, 0),
+	// 根据输出路径模板计算输出位置
+	err = ctl.applyOutTemplate(resInfo)
+	if err != nil {
+		return err
 	}
 
-	// 设置限速器
-	ctl.setSpeedLimit(ctl.config.SpeedLimit)
+	// 探测资源之后的钩子，可以修改输出位置或者取消下载
+	err = ctl.hookAfterProbe()
+	if err != nil {
+		return err
+	}
 
-	ctl.multithread = resInfo.multithread
-	ctl.totalSize = resInfo.filesize
-	ctl.threadCount = ctl.config.RoutineCount
-	ctl.breakpointResume = ctl.multithread && ctl.config.BreakpointResume
+	// 设置限速器
+	if schedule := ctl.getSpeedSchedule(); schedule != nil {
+		ctl.setSpeedLimit(schedule.Limit(time.Now()))
+	} else {
+		ctl.setSpeedLimit(ctl.config.SpeedLimit)
+	}
 
 	// 文件夹检查
 	if !fileExist(ctl.outdir) {
@@ -183,44 +273,256 @@ func (ctl *control) Init(ctx context.Context) error {
 
 	// 自动获取文件名
 	if ctl.outname == "" {
-		ctl.outname = resInfo.getFilename()
+		ctl.outname = resInfo.Filename
 	}
 
 	// 文件名非法字符过滤
-	if ctl.config.AutoFilterFilename {
-		ctl.outname = filterFileName(ctl.outname)
-	}
+	ctl.outname = ctl.filterFilename(ctl.outname)
 
 	// 文件检查
 	ctl.outpath, _ = filepath.Abs(filepath.Join(ctl.outdir, ctl.outname))
 	ctl.bpfilepath = filepath.Join(ctl.outdir, ctl.outname+ctl.config.BreakpointExt)
+
+	// 同步模式下资源没有变化时不需要下载
+	if ctl.config.SyncMode {
+		if !unchanged {
+			if meta == nil {
+				meta = ctl.loadSyncMeta()
+			}
+			unchanged = meta != nil && meta.unchanged(resInfo)
+		}
+		if unchanged {
+			ctl.setTotalSize(meta.Filesize)
+			atomic.StoreInt64(ctl.completedSize, meta.Filesize)
+			return errUnchanged
+		}
+		// 资源信息在下载完成后重新保存
+		if fileExist(ctl.outpath + ctl.config.MetaExt) {
+			os.Remove(ctl.outpath + ctl.config.MetaExt)
+		}
+	}
+
+	// 打开文件
+	err = ctl.openOutfile()
+	if err != nil {
+		return err
+	}
+
+	// 加载事件
+	ctl.loadEvent()
+
+	return nil
+}
+
+// openOutfile 打开输出文件，新建文件时独占创建，文件已经存在时按照配置续传、覆盖或者重命名
+// 同一个进程中其他下载正在使用的文件不能续传或者覆盖
+func (ctl *control) openOutfile() (err error) {
+	defer func() {
+		if err != nil {
+			ctl.releaseOutpath()
+		}
+	}()
 	isFileExist := fileExist(ctl.outpath)
 	isBpfileExist := fileExist(ctl.bpfilepath)
-	if isFileExist && (!ctl.breakpointResume || (!isBpfileExist && ctl.breakpointResume)) {
-		if ctl.config.AllowOverwrite {
-			err := os.Remove(ctl.outpath)
+	resume := isFileExist && ctl.breakpointResume && isBpfileExist
+	overwrite := ctl.config.AllowOverwrite || ctl.config.SyncMode
+	if !ctl.claimOutpath(ctl.outpath) {
+		isFileExist, resume, overwrite = true, false, false
+	}
+	if isFileExist && !resume {
+		if overwrite {
+			err = os.Remove(ctl.outpath)
 			if err != nil {
 				return err
 			}
-		} else if ctl.config.AutoFileRenaming {
-			// 文件重命名
-			ctl.outpath, ctl.outname = autoFileRenaming(ctl.outdir, ctl.outname)
-			ctl.bpfilepath = filepath.Join(ctl.outdir, ctl.outname+ctl.config.BreakpointExt)
-		} else {
+		} else if !ctl.config.AutoFileRenaming {
 			return os.ErrExist
 		}
 	}
+	if resume || overwrite {
+		ctl.outfile, err = os.OpenFile(ctl.outpath, os.O_CREATE|os.O_WRONLY, ctl.perm)
+	} else {
+		ctl.outfile, err = ctl.reserveOutfile()
+	}
+	return err
+}
 
-	// 打开文件
-	ctl.outfile, err = os.OpenFile(ctl.outpath, os.O_CREATE|os.O_WRONLY, ctl.perm)
+// prepareClient 加载 cookie 文件，有 TLS、代理、连接设置或者协议不同时当前下载使用复制的 transport，不影响其他下载
+func (ctl *control) prepareClient() error {
+	err := ctl.loadCookieFile()
 	if err != nil {
 		return err
 	}
+	// 当前下载的跳转策略
+	client := *ctl.request.client
+	client.CheckRedirect = ctl.checkRedirect(client.CheckRedirect)
+	ctl.request.client = &client
+	if !ctl.needTransport() {
+		return nil
+	}
+	var transport *http.Transport
+	switch v := ctl.request.client.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = v.Clone()
+	default:
+		return errors.New("transport not is http.Transport")
+	}
+	err = ctl.applyTLS(transport)
+	if err != nil {
+		return err
+	}
+	err = ctl.applyProxy(transport)
+	if err != nil {
+		return err
+	}
+	err = ctl.applyDialer(transport)
+	if err != nil {
+		return err
+	}
+	ctl.applyProtocol(transport)
+	ctl.closeIdleConnections()
+	ctl.request.client.Transport = transport
+	ctl.transport = transport
+	// 只需要应用一次
+	ctl.tls, ctl.proxies, ctl.dial = nil, nil, nil
+	return nil
+}
 
-	// 加载事件
+// closeIdleConnections 关闭当前下载复制的 transport 的闲置连接，复制的 transport 不会被其他下载复用
+func (ctl *control) closeIdleConnections() {
+	if ctl.transport != nil {
+		ctl.transport.CloseIdleConnections()
+	}
+}
+
+// getProto 获取最近一次下载请求使用的协议
+func (ctl *control) getProto() string {
+	proto, _ := ctl.proto.Load().(string)
+	return proto
+}
+
+// getTotalSize 获取资源大小
+func (ctl *control) getTotalSize() int64 {
+	return atomic.LoadInt64(&ctl.totalSize)
+}
+
+// setTotalSize 设置资源大小
+func (ctl *control) setTotalSize(size int64) {
+	atomic.StoreInt64(&ctl.totalSize, size)
+}
+
+// sizeUnknown 资源大小是否未知，未知时使用单个任务块下载到结束
+func (ctl *control) sizeUnknown() bool {
+	return ctl.breakpoint.Filesize < 0
+}
+
+// loadSyncMeta 同步模式下加载输出文件的资源信息，输出文件不存在或者大小不一致时返回 nil
+func (ctl *control) loadSyncMeta() *fileMeta {
+	if !ctl.config.SyncMode || ctl.outname == "" {
+		return nil
+	}
+	outname := ctl.outname
+	outname = ctl.filterFilename(outname)
+	outpath, _ := filepath.Abs(filepath.Join(ctl.outdir, outname))
+	meta, err := loadFileMeta(outpath + ctl.config.MetaExt)
+	if err != nil || !meta.matchFile(outpath) {
+		return nil
+	}
+	return meta
+}
+
+// exportSyncMeta 同步模式下保存输出文件的资源信息
+func (ctl *control) exportSyncMeta() error {
+	meta := &fileMeta{
+		URI:          ctl.uri,
+		Filesize:     atomic.LoadInt64(ctl.completedSize),
+		Etag:         ctl.resourceInfo.Etag,
+		LastModified: ctl.resourceInfo.LastModified,
+	}
+	return meta.export(ctl.outpath+ctl.config.MetaExt, ctl.perm)
+}
+
+// unchanged 资源没有变化，不需要下载
+func (ctl *control) unchanged() {
+	ctl.log("resource unchanged: ", ctl.outpath)
+	ctl.cancel()
+	ctl.setStatus(STATUS_UNCHANGED)
 	ctl.loadEvent()
+	if ctl.sendEvent != nil {
+		ctl.sendEvent()
+	}
+	ctl.done <- nil
+	close(ctl.done)
+}
 
-	return nil
+// setResourceInfo 根据资源探测信息重置断点和下载参数
+func (ctl *control) setResourceInfo(resInfo *ResourceInfo) {
+	ctl.resourceInfo = resInfo
+
+	// 未知大小时按照 0 处理
+	totalSize := resInfo.Filesize
+	if totalSize < 0 {
+		totalSize = 0
+	}
+
+	// 断点信息，未知大小时记录为 -1
+	ctl.breakpoint = &Breakpoint{
+		Filesize:     resInfo.Filesize,
+		Etag:         resInfo.Etag,
+		LastModified: resInfo.LastModified,
+		ContentType:  resInfo.ContentType,
+		Position:     0,
+		Tasks:        make([]*Block, 0),
+	}
+
+	ctl.multithread = resInfo.Multithread
+	ctl.setTotalSize(totalSize)
+	ctl.threadCount = ctl.routineCeiling()
+	// 单协程下载时通过 bytes=N- 从已下载的位置继续，服务器不支持时从头开始
+	ctl.breakpointResume = ctl.config.BreakpointResume
+
+	// range 请求时携带 If-Range，资源变化时服务器返回完整内容
+	ctl.request.ifRange = ifRangeValue(resInfo.Etag, resInfo.LastModified)
+
+	// 下载块直接请求跳转后的链接
+	ctl.request.finalURI = ""
+	if resInfo.FinalURI != ctl.request.uri {
+		ctl.request.finalURI = resInfo.FinalURI
+	}
+	ctl.breakpoint.FinalURI = resInfo.FinalURI
+
+	// 解压时只能单协程从头读取完整的内容
+	ctl.request.acceptEncoding = ""
+	if ctl.config.Decompress {
+		ctl.multithread = false
+		ctl.breakpointResume = false
+		ctl.request.acceptEncoding = acceptEncoding()
+	}
+}
+
+// probe 探测资源信息，不创建文件
+func (ctl *control) probe(ctx context.Context) (*ResourceInfo, error) {
+	ctl.packContext(ctx)
+	defer ctl.cancel()
+	defer ctl.closeIdleConnections()
+
+	err := ctl.prepareClient()
+	if err != nil {
+		return nil, err
+	}
+	err = ctl.hookBeforeProbe()
+	if err != nil {
+		return nil, err
+	}
+	resInfo, err := ctl.request.getResourceInfo(ctl.config.ProbeHead)
+	if err != nil {
+		return nil, err
+	}
+	// 文件名非法字符过滤
+	resInfo.Filename = ctl.filterFilename(resInfo.Filename)
+	return resInfo, nil
 }
 
 // wait 等待下载
@@ -249,34 +551,107 @@ func (ctl *control) setStatus(d Status) {
 	ctl.status = d
 }
 
-// setSpeedLimit 设置限速
+// setSpeedLimit 设置限速，运行中时直接修改已有的限速器
 func (ctl *control) setSpeedLimit(speedLimit int) {
 	ctl.mux.Lock()
 	defer ctl.mux.Unlock()
+	limit, burst := rate.Inf, 0
 	if speedLimit > 0 {
 		if speedLimit < COPY_BUFFER_SIZE {
 			speedLimit = COPY_BUFFER_SIZE
 		}
-		ctl.rate = rate.NewLimiter(rate.Limit(speedLimit), speedLimit)
+		limit, burst = rate.Limit(speedLimit), speedLimit
+		if ctl.config.SpeedBurst > 0 {
+			burst = ctl.config.SpeedBurst
+		}
+	}
+	if ctl.rate == nil {
+		ctl.rate = rate.NewLimiter(limit, burst)
 	} else {
-		ctl.rate = nil
+		ctl.rate.SetLimit(limit)
+		ctl.rate.SetBurst(burst)
 	}
 	ctl.config.SpeedLimit = speedLimit
 }
 
-// rateWaitN 消费限速器
-func (ctl *control) rateWaitN(n int) {
-	if ctl.rate == nil {
-		return
+// setSpeedSchedule 设置限速计划，运行中时立即生效
+func (ctl *control) setSpeedSchedule(schedule *SpeedSchedule) {
+	ctl.mux.Lock()
+	ctl.config.SpeedSchedule = schedule
+	ctl.mux.Unlock()
+	select {
+	case ctl.scheduleChange <- struct{}{}:
+	default:
 	}
+}
+
+// getSpeedSchedule 获取限速计划
+func (ctl *control) getSpeedSchedule() *SpeedSchedule {
 	ctl.mux.Lock()
 	defer ctl.mux.Unlock()
-	err := ctl.rate.WaitN(context.Background(), n)
-	if err != nil {
-		log.Println(err)
+	return ctl.config.SpeedSchedule
+}
+
+// autoSpeedSchedule 按照限速计划自动调整限速，直到本次运行的上下文结束
+func (ctl *control) autoSpeedSchedule(ctx context.Context, done chan<- struct{}) {
+	defer close(done)
+	for {
+		var (
+			timer *time.Timer
+			next  <-chan time.Time
+		)
+		if schedule := ctl.getSpeedSchedule(); schedule != nil {
+			now := time.Now()
+			ctl.setSpeedLimit(schedule.Limit(now))
+			if at := schedule.next(now); !at.IsZero() {
+				timer = time.NewTimer(at.Sub(now))
+				next = timer.C
+			}
+		}
+		select {
+		case <-next:
+		case <-ctl.scheduleChange:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if contextDone(ctx) {
+			return
+		}
 	}
 }
 
+// rateWaitN 消费限速器，不持有锁等待，上下文结束时立即返回
+// n 大于限速器的突发字节数时分多次消费
+func (ctl *control) rateWaitN(ctx context.Context, n int) error {
+	ctl.mux.Lock()
+	limiter := ctl.rate
+	ctl.mux.Unlock()
+	for n > 0 {
+		if limiter == nil || limiter.Limit() == rate.Inf {
+			return nil
+		}
+		burst := limiter.Burst()
+		if burst < 1 {
+			burst = 1
+		}
+		if burst > n {
+			burst = n
+		}
+		err := limiter.WaitN(ctx, burst)
+		if err != nil {
+			// 等待时间超过上下文的截止时间时提前返回，视为超时
+			if _, ok := ctx.Deadline(); ok && ctx.Err() == nil {
+				return fmt.Errorf("%v: %w", err, context.DeadlineExceeded)
+			}
+			return err
+		}
+		n -= burst
+	}
+	return nil
+}
+
 // setDebug 设置 debug
 func (ctl *control) setDebug(v bool) {
 	ctl.debug = v
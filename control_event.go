@@ -10,6 +10,11 @@ type ProgressEvent interface {
 	Change(stat *Stat)
 }
 
+// ProgressEventRestart 资源发生变化、已下载的数据被清空重新下载时的事件，ProgressEvent 可选实现
+type ProgressEventRestart interface {
+	Restart(stat *Stat)
+}
+
 // Stat 下载进行中的信息
 type Stat struct {
 	// Status 状态
@@ -18,8 +23,16 @@ type Stat struct {
 	TotalLength int64
 	// CompletedLength 已下载的文件大小
 	CompletedLength int64
+	// TransferLength 已接收的传输字节数，开启解压时为解码前的大小
+	TransferLength int64
+	// Proto 最近一次下载请求使用的协议，例如 HTTP/1.1、HTTP/2.0
+	Proto string
 	// Progress 进度
 	Progress int
+	// ProcessCompleted 下载完成后，当前处理步骤已完成的数量
+	ProcessCompleted int64
+	// ProcessTotal 下载完成后，当前处理步骤的总数量，未知时为 0
+	ProcessTotal int64
 	// Outpath 文件输出路径
 	Outpath string
 	// Error 下载错误信息
@@ -60,30 +73,56 @@ func (ctl *control) autoSendEvent() {
 	}
 }
 
+// newStat 获取当前的下载信息
+func (ctl *control) newStat() *Stat {
+	return &Stat{
+		Status:           ctl.status,
+		TotalLength:      ctl.getTotalSize(),
+		CompletedLength:  atomic.LoadInt64(ctl.completedSize),
+		TransferLength:   atomic.LoadInt64(ctl.transferSize),
+		Proto:            ctl.getProto(),
+		ProcessCompleted: atomic.LoadInt64(&ctl.processCompleted),
+		ProcessTotal:     atomic.LoadInt64(&ctl.processTotal),
+		Progress:         0,
+		Outpath:          ctl.outpath,
+		Error:            ctl.getError(),
+	}
+}
+
+// sendRestartEvent 发送重新下载事件
+func (ctl *control) sendRestartEvent() {
+	stat := ctl.newStat()
+	for _, e := range ctl.event {
+		if v, ok := e.(ProgressEventRestart); ok {
+			v.Restart(stat)
+		}
+	}
+}
+
 // sendEventFunc 发送事件信息
 func (ctl *control) sendEventFunc() func() {
 	var (
-		stat = &Stat{
-			Status:          ctl.status,
-			TotalLength:     ctl.totalSize,
-			CompletedLength: atomic.LoadInt64(ctl.completedSize),
-			Progress:        0,
-			Outpath:         ctl.outpath,
-			Error:           ctl.getError(),
-		}
+		stat               = ctl.newStat()
 		nowCompletedLength = int64(0)
 		remainingLength    = int64(0)
 	)
 	return func() {
 		nowCompletedLength = atomic.LoadInt64(ctl.completedSize)
-		remainingLength = ctl.totalSize - nowCompletedLength
+		totalSize := ctl.getTotalSize()
+		remainingLength = totalSize - nowCompletedLength
 		if remainingLength < 0 {
 			remainingLength = 0
 		}
 		stat.Status = ctl.status
+		// 未知大小的资源在读取完成后才能确定大小
+		stat.TotalLength = totalSize
 		stat.CompletedLength = nowCompletedLength
-		if nowCompletedLength > 0 && ctl.totalSize > 0 {
-			stat.Progress = int(float64(nowCompletedLength) / float64(ctl.totalSize) * float64(100))
+		stat.TransferLength = atomic.LoadInt64(ctl.transferSize)
+		stat.Proto = ctl.getProto()
+		stat.ProcessCompleted = atomic.LoadInt64(&ctl.processCompleted)
+		stat.ProcessTotal = atomic.LoadInt64(&ctl.processTotal)
+		if nowCompletedLength > 0 && totalSize > 0 {
+			stat.Progress = int(float64(nowCompletedLength) / float64(totalSize) * float64(100))
 		}
 		stat.Error = ctl.getError()
 		for _, e := range ctl.event {
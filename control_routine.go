@@ -0,0 +1,173 @@
+package rain
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// routineAutoInterval 自动调整协程数量时测量下载速度的间隔，测试中可以修改
+var routineAutoInterval = time.Second * 2
+
+// routineGate 限制同时下载的协程数量，数量可以在运行中调整
+type routineGate struct {
+	// mux 锁
+	mux sync.Mutex
+	// cond 等待空闲位置
+	cond *sync.Cond
+	// limit 允许同时下载的协程数量
+	limit int
+	// active 正在下载的协程数量
+	active int
+}
+
+// newRoutineGate 创建协程数量限制
+func newRoutineGate(limit int) *routineGate {
+	gate := &routineGate{limit: limit}
+	gate.cond = sync.NewCond(&gate.mux)
+	return gate
+}
+
+// acquire 等待空闲位置，上下文结束时返回 false
+func (gate *routineGate) acquire(ctx context.Context) bool {
+	if gate == nil {
+		return true
+	}
+	gate.mux.Lock()
+	defer gate.mux.Unlock()
+	for gate.active >= gate.limit {
+		if contextDone(ctx) {
+			return false
+		}
+		gate.cond.Wait()
+	}
+	gate.active++
+	return true
+}
+
+// release 释放位置
+func (gate *routineGate) release() {
+	if gate == nil {
+		return
+	}
+	gate.mux.Lock()
+	gate.active--
+	gate.mux.Unlock()
+	gate.cond.Signal()
+}
+
+// setLimit 调整允许同时下载的协程数量
+func (gate *routineGate) setLimit(limit int) {
+	gate.mux.Lock()
+	gate.limit = limit
+	gate.mux.Unlock()
+	gate.cond.Broadcast()
+}
+
+// getLimit 获取允许同时下载的协程数量
+func (gate *routineGate) getLimit() int {
+	if gate == nil {
+		return 0
+	}
+	gate.mux.Lock()
+	defer gate.mux.Unlock()
+	return gate.limit
+}
+
+// wakeup 唤醒所有等待的协程，用于上下文结束时退出
+func (gate *routineGate) wakeup() {
+	gate.mux.Lock()
+	gate.mux.Unlock()
+	gate.cond.Broadcast()
+}
+
+// routineTuner 根据下载速度调整协程数量
+// 增加协程后速度明显提升时继续增加，速度下降时撤销，速度持平时保持，并定期重新尝试增加
+type routineTuner struct {
+	// limit 当前协程数量
+	limit int
+	// max 协程数量上限
+	max int
+	// last 上次测量的速度
+	last int64
+	// grew 上次调整是否增加了协程
+	grew bool
+	// hold 速度持平后保持的次数
+	hold int
+}
+
+// routineTunerHold 速度持平后保持多少次测量再尝试增加协程
+const routineTunerHold = 5
+
+// next 根据本次测量的速度计算下一次的协程数量
+func (tuner *routineTuner) next(speed int64) int {
+	last := tuner.last
+	tuner.last = speed
+	switch {
+	case speed > last+last/10:
+		// 速度明显提升，继续增加
+		tuner.hold = 0
+		tuner.grow()
+	case tuner.grew && speed < last-last/10 && tuner.limit > 1:
+		// 增加协程后速度下降，撤销
+		tuner.limit--
+		tuner.grew = false
+		tuner.hold = 0
+	default:
+		// 速度持平，保持一段时间后再尝试增加
+		tuner.grew = false
+		tuner.hold++
+		if tuner.hold >= routineTunerHold {
+			tuner.hold = 0
+			tuner.grow()
+		}
+	}
+	return tuner.limit
+}
+
+// grow 增加一个协程
+func (tuner *routineTuner) grow() {
+	if tuner.limit < tuner.max {
+		tuner.limit++
+		tuner.grew = true
+	} else {
+		tuner.grew = false
+	}
+}
+
+// routineCeiling 获取协程数量上限，不超过 transport 中每个服务器的最大连接数
+func (ctl *control) routineCeiling() int {
+	ceiling := ctl.config.RoutineCount
+	if transport, ok := ctl.request.client.Transport.(*http.Transport); ok {
+		if transport.MaxConnsPerHost > 0 && transport.MaxConnsPerHost < ceiling {
+			ceiling = transport.MaxConnsPerHost
+		}
+	}
+	return ceiling
+}
+
+// autoRoutine 定期测量下载速度并调整协程数量，直到上下文结束
+func (ctl *control) autoRoutine(ctx context.Context, gate *routineGate) {
+	tuner := &routineTuner{limit: gate.getLimit(), max: ctl.threadCount}
+	ticker := time.NewTicker(routineAutoInterval)
+	defer ticker.Stop()
+	completed := atomic.LoadInt64(ctl.completedSize)
+	for {
+		select {
+		case <-ticker.C:
+			now := atomic.LoadInt64(ctl.completedSize)
+			speed := (now - completed) * int64(time.Second) / int64(routineAutoInterval)
+			completed = now
+			limit := tuner.next(speed)
+			if limit != gate.getLimit() {
+				ctl.log("goroutine count: ", limit, "speed: ", speed)
+				gate.setLimit(limit)
+			}
+		case <-ctx.Done():
+			gate.wakeup()
+			return
+		}
+	}
+}
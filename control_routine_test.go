@@ -0,0 +1,180 @@
+package rain
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRoutineTuner 测试根据下载速度调整协程数量
+func TestRoutineTuner(t *testing.T) {
+	tuner := &routineTuner{limit: 1, max: 4}
+	testData := []struct {
+		speed int64
+		limit int
+	}{
+		// 速度持续提升时增加协程
+		{100, 2},
+		{200, 3},
+		// 增加协程后速度下降时撤销
+		{150, 2},
+		// 速度持平时保持
+		{150, 2},
+		{150, 2},
+		{150, 2},
+		{150, 2},
+		// 持平一段时间后重新尝试增加
+		{150, 3},
+		{300, 4},
+		// 不超过上限
+		{600, 4},
+	}
+	for key, val := range testData {
+		if limit := tuner.next(val.speed); limit != val.limit {
+			t.Fatalf("%d 协程数量错误, 输出 %d, 应输出 %d", key, limit, val.limit)
+		}
+	}
+}
+
+// TestRoutineGate 测试协程数量限制
+func TestRoutineGate(t *testing.T) {
+	gate := newRoutineGate(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	if !gate.acquire(ctx) {
+		t.Fatal("应该获取到位置")
+	}
+	acquired := make(chan bool)
+	go func() {
+		acquired <- gate.acquire(ctx)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("超过限制时不应该获取到位置")
+	case <-time.After(time.Millisecond * 100):
+	}
+	// 调整数量后获取到位置
+	gate.setLimit(2)
+	if !<-acquired {
+		t.Fatal("调整数量后应该获取到位置")
+	}
+	// 上下文结束时退出等待
+	go func() {
+		acquired <- gate.acquire(ctx)
+	}()
+	cancel()
+	gate.wakeup()
+	if <-acquired {
+		t.Fatal("上下文结束时不应该获取到位置")
+	}
+	var nilGate *routineGate
+	if !nilGate.acquire(ctx) {
+		t.Fatal("没有限制时应该直接获取到位置")
+	}
+}
+
+// inflightTransport 统计同时进行中的请求数量，响应的 Body 关闭时请求结束
+type inflightTransport struct {
+	transport http.RoundTripper
+	inflight  int32
+	max       int32
+}
+
+// RoundTrip 发送请求并记录最大的同时请求数量
+func (it *inflightTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	n := atomic.AddInt32(&it.inflight, 1)
+	for {
+		m := atomic.LoadInt32(&it.max)
+		if n <= m || atomic.CompareAndSwapInt32(&it.max, m, n) {
+			break
+		}
+	}
+	res, err := it.transport.RoundTrip(req)
+	if err != nil {
+		atomic.AddInt32(&it.inflight, -1)
+		return nil, err
+	}
+	res.Body = &inflightBody{ReadCloser: res.Body, inflight: &it.inflight}
+	return res, nil
+}
+
+// inflightBody 关闭时减少进行中的请求数量
+type inflightBody struct {
+	io.ReadCloser
+	inflight *int32
+	once     sync.Once
+}
+
+// Close 关闭 Body
+func (b *inflightBody) Close() error {
+	b.once.Do(func() { atomic.AddInt32(b.inflight, -1) })
+	return b.ReadCloser.Close()
+}
+
+// TestRoutineAutoRetry 测试自动调整协程数量时，下载块重试仍然占用协程的位置
+func TestRoutineAutoRetry(t *testing.T) {
+	// 测试期间不调整协程数量，同时进行的请求不能超过一个
+	interval := routineAutoInterval
+	routineAutoInterval = time.Hour
+	defer func() { routineAutoInterval = interval }()
+
+	var (
+		data    = make([]byte, 1<<20)
+		mux     sync.Mutex
+		stalled = make(map[string]bool)
+	)
+	rand.Read(data)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hrange := r.Header.Get("range")
+		// 每个下载块第一次请求时不发送数据，触发读取空闲超时
+		mux.Lock()
+		first := hrange != fmt.Sprintf("bytes=0-%d", PROBE_SNIFF_SIZE-1) && !stalled[hrange]
+		stalled[hrange] = true
+		mux.Unlock()
+		if first {
+			w.Header().Set("content-length", "1")
+			w.WriteHeader(http.StatusPartialContent)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("etag", `"retry"`)
+		http.ServeContent(w, r, "retry.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	transport := &inflightTransport{transport: http.DefaultTransport.(*http.Transport).Clone()}
+	defer transport.transport.(*http.Transport).CloseIdleConnections()
+	rain := NewRain()
+	rain.SetClient(&http.Client{Transport: transport})
+	ctl, err := rain.New(
+		server.URL+"/retry.bin",
+		WithOutdir(t.TempDir()),
+		WithRoutineAuto(true),
+		WithRoutineCount(4),
+		WithRoutineSize(256<<10),
+		WithIdleTimeout(time.Millisecond*200),
+		WithRetryNumber(2),
+	).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := os.ReadFile(ctl.Outpath())
+	if err != nil || !bytes.Equal(d, data) {
+		t.Fatal("下载内容错误", err)
+	}
+	if limit := ctl.ctl.routine.getLimit(); limit != 1 {
+		t.Fatal("测试期间不应该调整协程数量", limit)
+	}
+	if max := atomic.LoadInt32(&transport.max); max > 1 {
+		t.Fatal("重试的下载块没有占用协程的位置", max)
+	}
+}
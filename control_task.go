@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"sync/atomic"
+	"time"
 )
 
 // startTask 开始任务
@@ -16,6 +17,31 @@ func (ctl *control) startTask() {
 	ctl.log("download start")
 	ctl.log("outpath: ", ctl.outpath)
 
+	ctl.setStatus(STATUS_RUNNING)
+
+	// 有发送进度事件时，启动自动发送事件 goroutine
+	if len(ctl.event) > 0 {
+		go ctl.autoSendEvent()
+	}
+
+	// 启动限速计划 goroutine，在 finish 中等待结束
+	ctl.scheduleDone = make(chan struct{})
+	go ctl.autoSpeedSchedule(ctl.ctx, ctl.scheduleDone)
+
+	err := ctl.runTask()
+	// 资源在下载过程中发生变化时，重新探测资源并从头开始下载一次
+	if errors.Is(err, ErrResourceChanged) && !contextDone(ctl.ctx) {
+		ctl.log("restart download: ", err)
+		err = ctl.restart()
+		if err == nil {
+			err = ctl.runTask()
+		}
+	}
+	ctl.finish(err)
+}
+
+// runTask 分配任务块并等待所有 goroutine 完成，返回第一个错误
+func (ctl *control) runTask() error {
 	// 任务块数量不会太多，提前生产出来
 	blocks := ctl.loadBlocks()
 
@@ -33,7 +59,17 @@ func (ctl *control) startTask() {
 
 	ctl.log("goroutine count: ", ctl.threadCount)
 
-	ctl.setStatus(STATUS_RUNNING)
+	// 本次运行结束时停止自动调整协程数量
+	ctx, cancel := context.WithCancel(ctl.ctx)
+	defer cancel()
+
+	// 自动调整协程数量时，从一个协程开始下载
+	ctl.routine = nil
+	if ctl.config.RoutineAuto && ctl.threadCount > 1 {
+		ctl.routine = newRoutineGate(1)
+		go ctl.autoRoutine(ctx, ctl.routine)
+	}
+
 	// taskchan 负责任务的发送与接收
 	taskchan := make(chan *Block)
 	// done 负责接收 goroutine 错误
@@ -46,11 +82,6 @@ func (ctl *control) startTask() {
 		go ctl.execute(taskchan, done)
 	}
 
-	// 有发送进度事件时，启动自动发送事件 goroutine
-	if len(ctl.event) > 0 {
-		go ctl.autoSendEvent()
-	}
-
 	// 将任务发送到 channel 传递给消费任务的 goroutine
 	go func() {
 	Allot:
@@ -76,10 +107,49 @@ func (ctl *control) startTask() {
 		}
 	}
 	if len(errs) == 0 {
-		ctl.finish(nil)
-	} else {
-		ctl.finish(errs[0])
+		return nil
 	}
+	return errs[0]
+}
+
+// useBreakpointFinalURI 续传时下载块继续请求断点中记录的跳转后的链接
+// 链接失效时请求中使用原始链接重新跳转，资源不一致时重新探测资源并从头下载
+func (ctl *control) useBreakpointFinalURI(bp *Breakpoint) {
+	// 旧的断点文件没有记录跳转后的链接，使用本次探测的结果
+	if bp.FinalURI == "" {
+		bp.FinalURI = ctl.breakpoint.FinalURI
+		return
+	}
+	ctl.request.finalURI = ""
+	if bp.FinalURI != ctl.request.uri {
+		ctl.request.finalURI = bp.FinalURI
+	}
+}
+
+// restart 资源发生变化时，重新探测资源，清空已下载的数据并发送重新下载事件
+func (ctl *control) restart() error {
+	resInfo, err := ctl.request.getResourceInfo(ctl.config.ProbeHead)
+	if err != nil {
+		return err
+	}
+	ctl.logf("resourceInfo: %#v\n", resInfo)
+	ctl.setResourceInfo(resInfo)
+	return ctl.discard()
+}
+
+// discard 清空已下载的数据和断点文件，并发送重新下载事件
+func (ctl *control) discard() error {
+	atomic.StoreInt64(ctl.completedSize, 0)
+	atomic.StoreInt64(ctl.transferSize, 0)
+	err := ctl.outfile.Truncate(0)
+	if err != nil {
+		return err
+	}
+	if fileExist(ctl.bpfilepath) {
+		os.Remove(ctl.bpfilepath)
+	}
+	ctl.sendRestartEvent()
+	return nil
 }
 
 // loadBlocks 加载任务块
@@ -91,23 +161,45 @@ func (ctl *control) loadBlocks() []*Block {
 	// 可以进行断点续传时，加载断点文件
 	if ctl.breakpointResume {
 		bp, err := loadBreakpoint(ctl.bpfilepath)
-		if err == nil && ctl.breakpoint.comparison(bp) {
-			ctl.breakpoint = bp
-			atomic.AddInt64(ctl.completedSize, bp.completedSize())
+		if err == nil {
+			if ctl.breakpoint.comparison(bp) {
+				ctl.useBreakpointFinalURI(bp)
+				// 旧格式的断点文件没有记录的信息使用本次探测的结果
+				if bp.LastModified == "" {
+					bp.LastModified = ctl.breakpoint.LastModified
+				}
+				if bp.ContentType == "" {
+					bp.ContentType = ctl.breakpoint.ContentType
+				}
+				ctl.breakpoint = bp
+				atomic.AddInt64(ctl.completedSize, bp.completedSize())
+			} else {
+				// 资源已经发生变化，断点中的数据不可用
+				ctl.log("breakpoint mismatch, restart download")
+				ctl.discard()
+			}
 		}
 	}
 	position := ctl.breakpoint.Position
+	// 未知大小时使用结束位置为 -1 的任务块，读取到 EOF 为止
+	if ctl.sizeUnknown() {
+		if len(ctl.breakpoint.Tasks) == 0 {
+			ctl.breakpoint.addTask(newBlock(position, -1))
+		}
+		return ctl.breakpoint.Tasks
+	}
+	totalSize := ctl.getTotalSize()
 	// 单协程任务分配
-	if position < ctl.totalSize && (ctl.totalSize == 0 || !ctl.multithread || ctl.config.RoutineCount == 1) {
-		ctl.breakpoint.addTask(newBlock(position, ctl.totalSize-1))
+	if position < totalSize && (!ctl.multithread || ctl.config.RoutineCount == 1) {
+		ctl.breakpoint.addTask(newBlock(position, totalSize-1))
 		return ctl.breakpoint.Tasks
 	}
 	// 多协程任务分配
-	for position < ctl.totalSize {
+	for position < totalSize {
 		start := position
 		end := position + ctl.config.RoutineSize
-		if end > ctl.totalSize-1 {
-			end = ctl.totalSize - 1
+		if end > totalSize-1 {
+			end = totalSize - 1
 		}
 		ctl.breakpoint.addTask(newBlock(start, end))
 		position = end + 1
@@ -118,11 +210,15 @@ func (ctl *control) loadBlocks() []*Block {
 // execute 执行任务的单个 goroutine
 // 不断地消费任务，直到没有任务或者出现错误
 func (ctl *control) execute(taskchan chan *Block, done chan error) {
-	for task := range taskchan {
-		if contextDone(ctl.ctx) {
+	for ctl.routine.acquire(ctl.ctx) {
+		task, ok := <-taskchan
+		if !ok || contextDone(ctl.ctx) {
+			ctl.routine.release()
 			break
 		}
-		err := ctl.download(task)
+		// 重试时继续占用位置，计入协程数量的限制
+		err := ctl.downloadRetry(task)
+		ctl.routine.release()
 		if err != nil {
 			done <- err
 			return
@@ -131,13 +227,34 @@ func (ctl *control) execute(taskchan chan *Block, done chan error) {
 	done <- nil
 }
 
+// downloadRetry 下载任务块，低速或读取空闲超时时重试，只有支持 range 请求时才能从中断的位置继续
+func (ctl *control) downloadRetry(task *Block) error {
+	err := ctl.download(task)
+	for retryNum := 0; (errors.Is(err, ErrStalled) || errors.Is(err, ErrIdleTimeout)) && ctl.multithread && retryNum < ctl.config.RetryNumber; retryNum++ {
+		ctl.log("block retry:", retryNum, task.Start, task.End, err)
+		err = ctl.download(task)
+	}
+	return err
+}
+
 // download 执行下载任务的具体实现
 func (ctl *control) download(task *Block) error {
+	// 复用下载时跳过已经完成的任务块
+	if task.End >= 0 && task.isFinish() {
+		return nil
+	}
 	var (
 		err  error
 		res  *http.Response
 		dest io.Writer
+		src  io.Reader
 	)
+	ctx, cancel := context.WithCancel(ctl.ctx)
+	defer cancel()
+
+	// 低速检测，收到响应头之后才开始计算，建立连接和等待响应的时间由 ConnectTimeout 和 ResponseHeaderTimeout 限制
+	monitor := ctl.newSpeedMonitor(cancel)
+
 	// 创建文件写入器
 	dest = newWriteFunc(func(b []byte) (n int, err error) {
 		n, err = ctl.outfile.WriteAt(b, task.Start)
@@ -151,36 +268,106 @@ func (ctl *control) download(task *Block) error {
 	})
 
 	// 当一次性下载完整文件时
-	if task.isAll(ctl.totalSize) {
-		res, err = ctl.request.defaultDo()
+	ranged := !task.isAll(ctl.getTotalSize())
+	if ranged {
+		res, err = ctl.request.rangeDo(ctx, task.Start, task.End)
 	} else {
-		res, err = ctl.request.rangeDo(task.Start, task.End)
+		res, err = ctl.request.defaultDo(ctx)
 	}
 	if err != nil {
-		return err
+		return ctl.stalledError(monitor, err)
 	}
 	defer res.Body.Close()
+	ctl.proto.Store(res.Proto)
+
+	// 单协程续传时服务器不支持 range 或者资源已经变化，返回了完整内容，从头开始写入
+	if ranged && !ctl.multithread && res.StatusCode == http.StatusOK {
+		ctl.log("range not satisfied, restart download")
+		err = ctl.discard()
+		if err != nil {
+			return err
+		}
+		task.Start = 0
+		ranged = false
+	}
+
+	// range 请求没有返回对应的部分内容，资源已经发生变化
+	if ranged && ctl.resourceChanged(res) {
+		return fmt.Errorf("%w: %s HTTP Status Code %d", ErrResourceChanged, ctl.uri, res.StatusCode)
+	}
+
+	src = newCountReader(res.Body, ctl.transferSize)
+	if monitor != nil {
+		go monitor.run(ctx)
+		src = monitor.reader(src)
+	}
+	// 读取空闲超时检测
+	idle := newIdleReader(src, ctl.config.IdleTimeout, cancel)
+	if idle != nil {
+		defer idle.stop()
+		src = idle
+	}
+
+	// 解码压缩传输的内容
+	decoded := false
+	if ctl.config.Decompress {
+		decoder, err := newDecoder(res.Header.Get("content-encoding"), src)
+		if err != nil {
+			return err
+		}
+		if decoder != nil {
+			defer decoder.Close()
+			src = decoder
+			decoded = true
+		}
+	}
 
 	// buffer size
 	bufsize := ctl.config.DiskCache
 	tasksize := task.uncompletedSize()
-	if int64(bufsize) > tasksize {
+	if task.End >= 0 && int64(bufsize) > tasksize {
 		bufsize = int(tasksize)
 	}
 
 	// 数据拷贝
-	_, err = ctl.iocopy(dest, res.Body, bufsize)
+	_, err = ctl.iocopy(ctx, dest, src, bufsize)
 	if err != nil {
-		return err
+		if idle.isIdle() && !contextDone(ctl.ctx) {
+			return fmt.Errorf("%w: %s", ErrIdleTimeout, ctl.config.IdleTimeout)
+		}
+		return ctl.stalledError(monitor, err)
+	}
+	// 未知大小或者解码后的大小与探测到的不同时，读取到 EOF 才能确定资源大小
+	if task.End < 0 || decoded {
+		task.End = task.Start - 1
+		ctl.setTotalSize(task.Start)
 	}
 	return nil
 }
 
+// resourceChanged range 请求的响应是否表示资源已经发生变化
+// If-Range 不匹配时服务器返回 200 和完整内容，资源大小变化时 content-range 中的大小不同
+func (ctl *control) resourceChanged(res *http.Response) bool {
+	if res.StatusCode != http.StatusPartialContent {
+		return true
+	}
+	size, totalSize := parseContentRangeSize(res.Header.Get("content-range")), ctl.getTotalSize()
+	return size >= 0 && totalSize > 0 && size != totalSize
+}
+
+// stalledError 因为低速取消请求时，将错误转换为 ErrStalled
+func (ctl *control) stalledError(monitor *speedMonitor, err error) error {
+	if monitor.isStalled() && !contextDone(ctl.ctx) {
+		return fmt.Errorf("%w: below %d B/s for %s", ErrStalled, monitor.limit, monitor.window)
+	}
+	return err
+}
+
 // COPY_BUFFER_SIZE 接收数据的 buffer 大小
 const COPY_BUFFER_SIZE = 1024 * 32
 
 // iocopy 拷贝数据
-func (ctl *control) iocopy(dst io.Writer, src io.Reader, bufsize int) (written int64, err error) {
+func (ctl *control) iocopy(ctx context.Context, dst io.Writer, src io.Reader, bufsize int) (written int64, err error) {
 	// 创建 buffer 缓冲区
 	dstbuf := bufio.NewWriterSize(dst, bufsize)
 	defer dstbuf.Flush()
@@ -192,7 +379,9 @@ func (ctl *control) iocopy(dst io.Writer, src io.Reader, bufsize int) (written i
 			return written, err
 		}
 		// 消费限速器
-		ctl.rateWaitN(n)
+		if werr := ctl.rateWaitN(ctx, n); werr != nil {
+			return written, werr
+		}
 		dstbuf.Write(buf[0:n])
 		nw64 := int64(n)
 		atomic.AddInt64(ctl.completedSize, nw64)
@@ -206,9 +395,19 @@ func (ctl *control) iocopy(dst io.Writer, src io.Reader, bufsize int) (written i
 
 // finish 执行下载结束后的善后工作
 func (ctl *control) finish(err error) {
+	// 输出文件
+	if ctl.outfile != nil {
+		ctl.outfile.Close()
+	}
+	// 下载成功后执行处理步骤
+	if err == nil && !ctl.isclose {
+		err = ctl.postProcess()
+	}
 	// 手动 Close
 	if ctl.isclose {
 		err = nil
+	} else {
+		err = ctl.hookAfterFinish(err)
 	}
 	// 上下文超时
 	if errors.Is(err, context.DeadlineExceeded) {
@@ -216,6 +415,14 @@ func (ctl *control) finish(err error) {
 	}
 	ctl.err = err
 	ctl.cancel()
+	// 等待限速计划 goroutine 结束，复用时不会影响下一次运行
+	if ctl.scheduleDone != nil {
+		<-ctl.scheduleDone
+	}
+	// 复制的 transport 的闲置连接
+	ctl.closeIdleConnections()
+	// 记录运行时间，暂停的时间不计入总超时时间
+	ctl.elapsed += time.Since(ctl.runStart)
 	// 断点文件
 	if fileExist(ctl.bpfilepath) {
 		if err == nil && !ctl.isclose {
@@ -224,10 +431,16 @@ func (ctl *control) finish(err error) {
 			ctl.breakpoint.export(ctl.bpfilepath, ctl.perm)
 		}
 	}
-	// 输出文件
-	if ctl.outfile != nil {
-		ctl.outfile.Close()
+	// 保存 cookie 文件
+	ctl.saveCookieFile()
+	// 同步模式下保存资源信息
+	if ctl.config.SyncMode && err == nil && !ctl.isclose {
+		if merr := ctl.exportSyncMeta(); merr != nil {
+			ctl.log("export meta: ", merr)
+		}
 	}
+	// 断点文件处理完成后才释放输出路径，其他下载不会把未完成的文件当作断点续传
+	ctl.releaseOutpath()
 
 	// 设置完成状态
 	if ctl.err != nil {
@@ -0,0 +1,241 @@
+package rain
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// httpOnlyPrefix cookies.txt 中 HttpOnly cookie 的域名前缀
+const httpOnlyPrefix = "#HttpOnly_"
+
+// CookieJar 可以导入和导出 Netscape cookies.txt 格式的 cookie jar
+type CookieJar struct {
+	jar *cookiejar.Jar
+	mux sync.Mutex
+	// cookies 保存的 cookie，用于导出
+	cookies map[string]*jarCookie
+}
+
+// jarCookie 保存的 cookie 及其作用范围
+type jarCookie struct {
+	// domain 域名，包含子域名时以 . 开头
+	domain string
+	cookie *http.Cookie
+}
+
+var _ http.CookieJar = &CookieJar{}
+
+// NewCookieJar 创建 cookie jar
+func NewCookieJar() *CookieJar {
+	jar, _ := cookiejar.New(nil)
+	return &CookieJar{
+		jar:     jar,
+		cookies: make(map[string]*jarCookie),
+	}
+}
+
+// LoadCookieFile 从 Netscape cookies.txt 格式的文件创建 cookie jar
+func LoadCookieFile(path string) (*CookieJar, error) {
+	jar := NewCookieJar()
+	err := jar.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return jar, nil
+}
+
+// SetCookies 保存响应中的 cookie
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	for _, c := range cookies {
+		// 与 cookiejar 一样忽略域名不匹配的 cookie，导出后重新加载时不能发送给其他域名
+		domain, ok := cookieDomain(strings.ToLower(u.Hostname()), c.Domain)
+		if !ok {
+			continue
+		}
+		cookie := *c
+		if cookie.Path == "" || cookie.Path[0] != '/' {
+			cookie.Path = defaultCookiePath(u.Path)
+		}
+		if cookie.MaxAge > 0 {
+			cookie.Expires = time.Now().Add(time.Duration(cookie.MaxAge) * time.Second)
+		}
+		key := domain + ";" + cookie.Path + ";" + cookie.Name
+		if cookie.MaxAge < 0 || (!cookie.Expires.IsZero() && cookie.Expires.Before(time.Now())) {
+			delete(j.cookies, key)
+			continue
+		}
+		j.cookies[key] = &jarCookie{domain: domain, cookie: &cookie}
+	}
+}
+
+// cookieDomain 获取 cookie 的作用域名，包含子域名时以 . 开头，域名不匹配 host 时返回 false
+// 判断规则与 cookiejar 相同
+func cookieDomain(host, domain string) (string, bool) {
+	if domain == "" {
+		return host, true
+	}
+	// IP 地址只能是 host cookie
+	if net.ParseIP(host) != nil {
+		return host, host == domain
+	}
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	if domain == "" || domain[0] == '.' || domain[len(domain)-1] == '.' {
+		return "", false
+	}
+	if host != domain && !strings.HasSuffix(host, "."+domain) {
+		return "", false
+	}
+	return "." + domain, true
+}
+
+// Cookies 获取请求需要携带的 cookie
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// Load 加载 Netscape cookies.txt 格式的文件，已过期的 cookie 会被忽略
+func (j *CookieJar) Load(path string) error {
+	d, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(d))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		httpOnly := strings.HasPrefix(text, httpOnlyPrefix)
+		if httpOnly {
+			text = strings.TrimPrefix(text, httpOnlyPrefix)
+		}
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("cookies.txt line %d: expected 7 fields, got %d", line, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("cookies.txt line %d: %w", line, err)
+		}
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		// 过期时间为 0 时是会话 cookie
+		if expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+			if cookie.Expires.Before(time.Now()) {
+				continue
+			}
+		}
+		host := strings.TrimPrefix(fields[0], ".")
+		if strings.EqualFold(fields[1], "TRUE") {
+			cookie.Domain = host
+		}
+		scheme := "http"
+		if cookie.Secure {
+			scheme = "https"
+		}
+		j.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: cookie.Path}, []*http.Cookie{cookie})
+	}
+	return scanner.Err()
+}
+
+// Save 保存为 Netscape cookies.txt 格式的文件
+func (j *CookieJar) Save(path string) error {
+	j.mux.Lock()
+	keys := make([]string, 0, len(j.cookies))
+	for k := range j.cookies {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	buf.WriteString("# Netscape HTTP Cookie File\n")
+	now := time.Now()
+	for _, k := range keys {
+		v := j.cookies[k]
+		c := v.cookie
+		if !c.Expires.IsZero() && c.Expires.Before(now) {
+			continue
+		}
+		var expires int64
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Unix()
+		}
+		domain := v.domain
+		if c.HttpOnly {
+			domain = httpOnlyPrefix + domain
+		}
+		fmt.Fprintf(&buf, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain, netscapeBool(strings.HasPrefix(v.domain, ".")), c.Path, netscapeBool(c.Secure), expires, c.Name, c.Value)
+	}
+	j.mux.Unlock()
+	return os.WriteFile(path, buf.Bytes(), 0600)
+}
+
+// netscapeBool cookies.txt 中的布尔值
+func netscapeBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// defaultCookiePath cookie 没有设置 Path 时的默认值
+func defaultCookiePath(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+// setCookieJar 当前下载使用复制的请求客户端和 cookie jar，不影响其他下载
+func (ctl *control) setCookieJar(jar http.CookieJar) {
+	client := *ctl.request.client
+	client.Jar = jar
+	ctl.request.client = &client
+}
+
+// loadCookieFile 加载 cookie 文件，需要保存时文件不存在视为空文件
+func (ctl *control) loadCookieFile() error {
+	if ctl.cookieFile == "" || ctl.cookieJar != nil {
+		return nil
+	}
+	jar := NewCookieJar()
+	err := jar.Load(ctl.cookieFile)
+	if err != nil && !(ctl.cookieSave && os.IsNotExist(err)) {
+		return err
+	}
+	ctl.cookieJar = jar
+	ctl.setCookieJar(jar)
+	return nil
+}
+
+// saveCookieFile 下载结束后保存更新的 cookie
+func (ctl *control) saveCookieFile() {
+	if !ctl.cookieSave || ctl.cookieJar == nil {
+		return
+	}
+	if err := ctl.cookieJar.Save(ctl.cookieFile); err != nil {
+		ctl.log("save cookie file: ", err)
+	}
+}
@@ -0,0 +1,108 @@
+package rain
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCookieJar(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cookies.txt")
+	expires := time.Now().Add(time.Hour).Unix()
+	d := strings.Join([]string{
+		"# Netscape HTTP Cookie File",
+		".example.com\tTRUE\t/\tFALSE\t" + strconv.FormatInt(expires, 10) + "\tsession\tabc",
+		"#HttpOnly_api.example.com\tFALSE\t/v1\tTRUE\t0\ttoken\txyz",
+		"old.example.org\tFALSE\t/\tFALSE\t1\texpired\tvalue",
+		"",
+	}, "\n")
+	os.WriteFile(path, []byte(d), 0600)
+
+	jar, err := LoadCookieFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []struct {
+		uri     string
+		cookies string
+	}{
+		{"http://www.example.com/", "session=abc"},
+		{"https://api.example.com/v1/file", "token=xyz; session=abc"},
+		{"http://api.example.com/v1/file", "session=abc"},
+		{"http://old.example.org/", ""},
+	}
+	check := func(jar *CookieJar) {
+		for _, v := range data {
+			u, _ := url.Parse(v.uri)
+			var cookies []string
+			for _, c := range jar.Cookies(u) {
+				cookies = append(cookies, c.Name+"="+c.Value)
+			}
+			if strings.Join(cookies, "; ") != v.cookies {
+				t.Fatal(v.uri, cookies)
+			}
+		}
+	}
+	check(jar)
+
+	// 导出后重新加载
+	err = jar.Save(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar, err = LoadCookieFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(jar)
+
+	os.WriteFile(path, []byte("example.com\tTRUE\t/\n"), 0600)
+	if _, err = LoadCookieFile(path); err == nil {
+		t.Fatal("格式错误时应该返回错误")
+	}
+}
+
+func TestCookieJarDomain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.txt")
+	jar := NewCookieJar()
+	evil, _ := url.Parse("http://evil.test/")
+	jar.SetCookies(evil, []*http.Cookie{
+		{Name: "stolen", Value: "1", Domain: "bank.test"},
+		{Name: "own", Value: "2", Domain: ".evil.test"},
+	})
+	ip, _ := url.Parse("http://127.0.0.1/")
+	jar.SetCookies(ip, []*http.Cookie{
+		{Name: "other", Value: "3", Domain: "127.0.0.2"},
+		{Name: "local", Value: "4", Domain: "127.0.0.1"},
+	})
+	err := jar.Save(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar, err = LoadCookieFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]string{
+		"http://bank.test/":     "",
+		"http://www.evil.test/": "own=2",
+		"http://127.0.0.2/":     "",
+		"http://127.0.0.1/":     "local=4",
+	}
+	for uri, want := range data {
+		u, _ := url.Parse(uri)
+		var cookies []string
+		for _, c := range jar.Cookies(u) {
+			cookies = append(cookies, c.Name+"="+c.Value)
+		}
+		if strings.Join(cookies, "; ") != want {
+			t.Fatal("域名不匹配的 cookie 被导出", uri, cookies)
+		}
+	}
+}
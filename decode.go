@@ -0,0 +1,63 @@
+package rain
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Decoder 创建解码 Content-Encoding 的 reader
+type Decoder func(r io.Reader) (io.ReadCloser, error)
+
+var (
+	// decoderMux 解码器锁
+	decoderMux sync.RWMutex
+	// decoders 已注册的解码器，key 为小写的 Content-Encoding
+	decoders = map[string]Decoder{
+		"gzip": func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+		"deflate": func(r io.Reader) (io.ReadCloser, error) {
+			return zlib.NewReader(r)
+		},
+	}
+)
+
+// RegisterDecoder 注册 Content-Encoding 解码器，例如 br、zstd，已存在时覆盖
+// 开启解压后，已注册的编码都会出现在请求的 accept-encoding 中
+func RegisterDecoder(encoding string, decoder Decoder) {
+	decoderMux.Lock()
+	defer decoderMux.Unlock()
+	decoders[strings.ToLower(encoding)] = decoder
+}
+
+// acceptEncoding 获取请求使用的 accept-encoding
+func acceptEncoding() string {
+	decoderMux.RLock()
+	defer decoderMux.RUnlock()
+	encodings := make([]string, 0, len(decoders))
+	for k := range decoders {
+		encodings = append(encodings, k)
+	}
+	sort.Strings(encodings)
+	return strings.Join(encodings, ", ")
+}
+
+// newDecoder 根据 Content-Encoding 创建解码 reader，没有编码时返回 nil
+func newDecoder(encoding string, r io.Reader) (io.ReadCloser, error) {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	if encoding == "" || encoding == "identity" {
+		return nil, nil
+	}
+	decoderMux.RLock()
+	decoder, ok := decoders[encoding]
+	decoderMux.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encoding)
+	}
+	return decoder(r)
+}
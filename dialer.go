@@ -0,0 +1,159 @@
+package rain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Resolver 域名解析，*net.Resolver 实现了该接口，也可以使用 DNS-over-HTTPS 等自定义实现
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// ResolverFunc 使用函数实现 Resolver
+type ResolverFunc func(ctx context.Context, host string) ([]string, error)
+
+// LookupHost 解析域名
+func (f ResolverFunc) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return f(ctx, host)
+}
+
+// dialSettings 建立连接的设置，开始下载时应用到复制的 transport
+type dialSettings struct {
+	// localAddr 绑定的本地地址
+	localAddr string
+	// iface 绑定的网卡名称
+	iface string
+	// hosts 静态域名解析，key 为 host 或者 host:port
+	hosts map[string][]string
+	// resolver 域名解析
+	resolver Resolver
+}
+
+// dialer 绑定本地地址并使用自定义域名解析建立连接
+type dialer struct {
+	dialer   *net.Dialer
+	localIPs []net.IP
+	hosts    map[string][]string
+	resolver Resolver
+}
+
+// newDialer 根据设置创建 dialer
+func (s *dialSettings) newDialer() (*dialer, error) {
+	d := &dialer{
+		dialer:   &net.Dialer{KeepAlive: 30 * time.Second},
+		hosts:    s.hosts,
+		resolver: s.resolver,
+	}
+	if s.localAddr != "" {
+		ip := net.ParseIP(s.localAddr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid local address: %q", s.localAddr)
+		}
+		d.localIPs = append(d.localIPs, ip)
+	}
+	if s.iface != "" {
+		iface, err := net.InterfaceByName(s.iface)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				d.localIPs = append(d.localIPs, ipnet.IP)
+			}
+		}
+		if len(d.localIPs) == 0 {
+			return nil, fmt.Errorf("interface %s has no address", s.iface)
+		}
+	}
+	return d, nil
+}
+
+// DialContext 建立连接，依次尝试解析到的地址
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := d.lookup(ctx, host, port)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for _, ip := range ips {
+		dialer := *d.dialer
+		if local := d.localIP(ip); local != nil {
+			dialer.LocalAddr = &net.TCPAddr{IP: local}
+		} else if len(d.localIPs) > 0 {
+			lastErr = fmt.Errorf("no local address for %s", ip)
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no address for " + host)
+	}
+	return nil, lastErr
+}
+
+// lookup 解析域名，优先使用静态域名解析
+func (d *dialer) lookup(ctx context.Context, host, port string) ([]string, error) {
+	if ips, ok := d.hosts[strings.ToLower(net.JoinHostPort(host, port))]; ok {
+		return ips, nil
+	}
+	if ips, ok := d.hosts[strings.ToLower(host)]; ok {
+		return ips, nil
+	}
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	if d.resolver != nil {
+		return d.resolver.LookupHost(ctx, host)
+	}
+	return net.DefaultResolver.LookupHost(ctx, host)
+}
+
+// localIP 获取与目标地址类型相同的本地地址，没有绑定本地地址时返回 nil
+func (d *dialer) localIP(target string) net.IP {
+	ip := net.ParseIP(target)
+	for _, v := range d.localIPs {
+		if ip == nil || (v.To4() != nil) == (ip.To4() != nil) {
+			return v
+		}
+	}
+	return nil
+}
+
+// dialSettings 获取当前下载的连接设置
+func (ctl *control) dialSettings() *dialSettings {
+	if ctl.dial == nil {
+		ctl.dial = &dialSettings{hosts: make(map[string][]string)}
+	}
+	return ctl.dial
+}
+
+// applyDialer 将当前下载的连接设置应用到复制的 transport
+func (ctl *control) applyDialer(transport *http.Transport) error {
+	if ctl.dial == nil {
+		return nil
+	}
+	d, err := ctl.dial.newDialer()
+	if err != nil {
+		return err
+	}
+	transport.DialContext = d.DialContext
+	return nil
+}
@@ -0,0 +1,38 @@
+package rain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrStalled 下载速度持续低于 LowSpeedLimit，重试后仍然没有恢复
+	ErrStalled = errors.New("download stalled")
+	// ErrResourceChanged 资源在下载过程中发生了变化，会重新下载一次
+	ErrResourceChanged = errors.New("resource changed")
+	// ErrConnectTimeout 建立连接超时
+	ErrConnectTimeout = fmt.Errorf("connect timeout: %w", context.DeadlineExceeded)
+	// ErrResponseHeaderTimeout 等待响应头超时
+	ErrResponseHeaderTimeout = fmt.Errorf("response header timeout: %w", context.DeadlineExceeded)
+	// ErrIdleTimeout 读取数据时超过 IdleTimeout 没有收到任何数据
+	ErrIdleTimeout = fmt.Errorf("read idle timeout: %w", context.DeadlineExceeded)
+	// ErrUnsupportedEncoding 开启解压时服务器返回了没有注册解码器的 Content-Encoding
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+	// ErrUnsupportedArchive 无法识别的压缩包格式
+	ErrUnsupportedArchive = errors.New("unsupported archive")
+	// ErrUnsafeArchivePath 压缩包中的路径超出了解压目录
+	ErrUnsafeArchivePath = errors.New("unsafe archive path")
+
+	// ErrPinMismatch 服务器证书的公钥与固定的公钥不匹配
+	ErrPinMismatch = errors.New("certificate pin mismatch")
+
+	// ErrTooManyRedirects 跳转次数超过了 MaxRedirects
+	ErrTooManyRedirects = errors.New("too many redirects")
+	// ErrRedirectDenied 跳转被跳转策略拒绝
+	ErrRedirectDenied = errors.New("redirect denied")
+
+	// errUnchanged 同步模式下资源没有变化
+	errUnchanged = errors.New("resource unchanged")
+)
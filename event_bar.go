@@ -115,7 +115,7 @@ func (bar *Bar) change(stat *EventExtend) {
 	} else {
 		templateEntity = bar.Template.Template
 	}
-	if stat.Status.Is(STATUS_FINISH, STATUS_CLOSE, STATUS_ERROR) {
+	if stat.Status.Is(STATUS_FINISH, STATUS_UNCHANGED, STATUS_CLOSE, STATUS_ERROR) {
 		// 下载完成后清除进度条
 		if bar.FinishHide {
 			fmt.Printf("\r%s\r", strings.Repeat(" ", bar.Template.BarWidth))
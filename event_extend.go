@@ -16,6 +16,11 @@ type ProgressEventExtend interface {
 	Finish(stat *EventExtend)
 }
 
+// ProgressEventExtendRestart 资源发生变化、已下载的数据被清空重新下载时的事件，ProgressEventExtend 可选实现
+type ProgressEventExtendRestart interface {
+	Restart(stat *EventExtend)
+}
+
 type EventExtend struct {
 	// Stat 信息
 	*Stat
@@ -31,7 +36,10 @@ type EventExtend struct {
 	oldCompletedLength int64
 }
 
-var _ ProgressEvent = &EventExtend{}
+var (
+	_ ProgressEvent        = &EventExtend{}
+	_ ProgressEventRestart = &EventExtend{}
+)
 
 func NewEventExtend(e ...ProgressEventExtend) ProgressEvent {
 	return &EventExtend{
@@ -98,10 +106,22 @@ func (se *EventExtend) Change(stat *Stat) {
 		return
 	}
 
-	if se.Status.Is(STATUS_FINISH) {
+	if se.Status.Is(STATUS_FINISH, STATUS_UNCHANGED) {
 		se.sendEvent("finish")
 		return
 	}
 
 	se.sendEvent("change")
 }
+
+// Restart 重新下载，重置下载速度的记录
+func (se *EventExtend) Restart(stat *Stat) {
+	se.Stat = stat
+	se.oldCompletedLength = stat.CompletedLength
+	se.record = se.record[:0]
+	for _, event := range se.events {
+		if v, ok := event.(ProgressEventExtendRestart); ok {
+			v.Restart(se)
+		}
+	}
+}
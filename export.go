@@ -1,6 +1,8 @@
 package rain
 
 import (
+	"context"
+	"crypto/tls"
 	"io"
 	"io/fs"
 	"net/http"
@@ -16,11 +18,21 @@ func New(uri string, opts ...OptionFunc) *RainControl {
 	return std.New(uri, opts...)
 }
 
+// Probe 探测资源的大小、类型和文件名等信息，不下载资源
+func Probe(ctx context.Context, uri string, opts ...OptionFunc) (*ResourceInfo, error) {
+	return std.Probe(ctx, uri, opts...)
+}
+
 // SetProxy 设置客户端代理
 func SetProxy(p func(*http.Request) (*url.URL, error), h ...http.Header) error {
 	return std.SetProxy(p, h...)
 }
 
+// Use 添加默认的下载生命周期钩子，在 WithHook 添加的钩子之前执行
+func Use(h ...Hook) {
+	std.Use(h...)
+}
+
 // AddOptions 添加 New 时的 option
 func AddOptions(opt ...OptionFunc) {
 	std.AddOptions(opt...)
@@ -41,6 +53,61 @@ func SetRoutineCount(d int) {
 	std.SetRoutineCount(d)
 }
 
+// SetRoutineAuto 设置是否根据下载速度自动调整协程数量，协程最大数为上限
+func SetRoutineAuto(d bool) {
+	std.SetRoutineAuto(d)
+}
+
+// SetMaxRedirects 设置最多跳转次数
+func SetMaxRedirects(d int) {
+	std.SetMaxRedirects(d)
+}
+
+// SetProtocol 设置下载使用的 HTTP 协议
+func SetProtocol(d Protocol) {
+	std.SetProtocol(d)
+}
+
+// SetProxyURL 设置客户端代理，支持 http、https、socks5 和 socks5h，认证信息写在链接中，多个代理时每个请求轮流使用
+func SetProxyURL(proxies ...string) error {
+	return std.SetProxyURL(proxies...)
+}
+
+// SetTLSConfig 设置默认请求客户端的 TLS 配置
+func SetTLSConfig(cfg *tls.Config) {
+	std.SetTLSConfig(cfg)
+}
+
+// SetCACertFile 默认请求客户端在系统证书的基础上添加 PEM 格式的 CA 证书
+func SetCACertFile(path string) error {
+	return std.SetCACertFile(path)
+}
+
+// SetClientCert 默认请求客户端使用 PEM 格式的客户端证书和私钥进行双向认证
+func SetClientCert(certFile, keyFile string) error {
+	return std.SetClientCert(certFile, keyFile)
+}
+
+// SetTLSMinVersion 设置默认请求客户端的最低 TLS 版本
+func SetTLSMinVersion(v uint16) error {
+	return std.SetTLSMinVersion(v)
+}
+
+// SetPinnedSPKI 默认请求客户端固定服务器证书的公钥
+func SetPinnedSPKI(pins ...string) error {
+	return std.SetPinnedSPKI(pins...)
+}
+
+// SetInsecureSkipVerify 默认请求客户端跳过服务器证书验证，只用于无法验证证书的旧服务器
+func SetInsecureSkipVerify(d bool) error {
+	return std.SetInsecureSkipVerify(d)
+}
+
+// SetCookieJar 设置默认请求客户端的 cookie jar
+func SetCookieJar(jar http.CookieJar) {
+	std.SetCookieJar(jar)
+}
+
 // SetClient 设置默认请求客户端
 func SetClient(d *http.Client) {
 	std.SetClient(d)
@@ -86,6 +153,26 @@ func SetSpeedLimit(d int) {
 	std.SetSpeedLimit(d)
 }
 
+// SetSpeedBurst 设置限速器允许的突发字节数
+func SetSpeedBurst(d int) {
+	std.SetSpeedBurst(d)
+}
+
+// SetSpeedSchedule 设置分时段限速计划
+func SetSpeedSchedule(d *SpeedSchedule) {
+	std.SetSpeedSchedule(d)
+}
+
+// SetLowSpeedLimit 设置低速检测，下载块在 window 内速度持续低于 bytesPerSec 时重试，重试后仍然低速返回 ErrStalled
+func SetLowSpeedLimit(bytesPerSec int, window time.Duration) {
+	std.SetLowSpeedLimit(bytesPerSec, window)
+}
+
+// SetProbeHead 设置探测资源时是否先尝试 HEAD 请求
+func SetProbeHead(d bool) {
+	std.SetProbeHead(d)
+}
+
 // SetCreateDir 设置是否可以创建目录
 func SetCreateDir(d bool) {
 	std.SetCreateDir(d)
@@ -106,11 +193,46 @@ func SetAutoFileRenaming(d bool) {
 	std.SetAutoFileRenaming(d)
 }
 
-// SetTimeout 设置下载超时时间
+// SetRenameStrategy 设置自动重命名的规则
+func SetRenameStrategy(d RenameStrategy) {
+	std.SetRenameStrategy(d)
+}
+
+// SetRenameMaxAttempts 设置自动重命名的最多次数，超过后返回 *RenameError
+func SetRenameMaxAttempts(d int) {
+	std.SetRenameMaxAttempts(d)
+}
+
+// SetFilenamePolicy 设置过滤文件名称时使用的规则
+func SetFilenamePolicy(d FilenamePolicy) {
+	std.SetFilenamePolicy(d)
+}
+
+// SetFilenameReplacement 设置替换文件名称中非法字符的字符串，为空时直接删除非法字符
+func SetFilenameReplacement(d string) {
+	std.SetFilenameReplacement(d)
+}
+
+// SetTimeout 设置下载总超时时间，暂停的时间不计入
 func SetTimeout(d time.Duration) {
 	std.SetTimeout(d)
 }
 
+// SetConnectTimeout 设置建立连接的超时时间
+func SetConnectTimeout(d time.Duration) {
+	std.SetConnectTimeout(d)
+}
+
+// SetResponseHeaderTimeout 设置建立连接后等待响应头的超时时间
+func SetResponseHeaderTimeout(d time.Duration) {
+	std.SetResponseHeaderTimeout(d)
+}
+
+// SetIdleTimeout 设置读取数据时的空闲超时时间
+func SetIdleTimeout(d time.Duration) {
+	std.SetIdleTimeout(d)
+}
+
 // SetRetryNumber 设置请求重试次数
 func SetRetryNumber(d int) {
 	std.SetRetryNumber(d)
@@ -125,3 +247,23 @@ func SetRetryTime(d time.Duration) {
 func SetBreakpointExt(d string) {
 	std.SetBreakpointExt(d)
 }
+
+// SetDecompress 请求压缩传输并将内容解码后写入文件，只使用单协程下载
+func SetDecompress(d bool) {
+	std.SetDecompress(d)
+}
+
+// SetSyncMode 设置同步模式，输出文件已经存在时只在资源发生变化时重新下载并覆盖
+func SetSyncMode(d bool) {
+	std.SetSyncMode(d)
+}
+
+// SetMetaExt 同步模式下保存资源信息的文件扩展, 默认为 .meta.rain
+func SetMetaExt(d string) {
+	std.SetMetaExt(d)
+}
+
+// SetOutTemplate 输出路径模板，没有设置输出文件名时根据资源信息计算相对于输出目录的路径
+func SetOutTemplate(tmpl string) {
+	std.SetOutTemplate(tmpl)
+}
@@ -0,0 +1,445 @@
+package rain
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Extractor 下载完成后解压 .zip、.tar、.tar.gz 压缩包和单个文件的 .gz 的处理步骤
+type Extractor struct {
+	// Dir 解压目录，为空时解压到压缩包所在的目录
+	Dir string
+	// RemoveArchive 解压完成后删除压缩包
+	RemoveArchive bool
+	// Overwrite 覆盖解压目录中已经存在的文件，为 false 时使用下载的 AllowOverwrite 设置，不允许覆盖时返回 os.ErrExist
+	Overwrite bool
+}
+
+var _ PostProcessor = &Extractor{}
+
+// NewExtractor 创建解压处理步骤
+func NewExtractor(dir string) *Extractor {
+	return &Extractor{Dir: dir}
+}
+
+// Process 解压下载完成的压缩包
+func (e *Extractor) Process(ctx context.Context, p *PostProcess) error {
+	dir := e.Dir
+	if dir == "" {
+		dir = filepath.Dir(p.Outpath)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	ex := &extraction{
+		dir:       dir,
+		overwrite: e.Overwrite || p.ctl != nil && p.ctl.config != nil && p.ctl.config.AllowOverwrite,
+		created:   make(map[string]bool),
+	}
+	switch archiveFormat(p) {
+	case "zip":
+		err = extractZip(ctx, p, ex)
+	case "tar":
+		err = extractTar(ctx, p, ex, false)
+	case "tar.gz":
+		err = extractTar(ctx, p, ex, true)
+	case "gz":
+		err = extractGzip(ctx, p, ex)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedArchive, filepath.Base(p.Outpath))
+	}
+	if err != nil {
+		return err
+	}
+	if e.RemoveArchive {
+		return os.Remove(p.Outpath)
+	}
+	return nil
+}
+
+// archiveFormat 根据文件名获取压缩包格式，文件名无法判断时使用探测到的文件类型
+// 探测到 gzip 时根据解压后的内容区分 tar.gz 和单个文件的 gz
+func archiveFormat(p *PostProcess) string {
+	name := strings.ToLower(p.Outpath)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".gz"):
+		return "gz"
+	}
+	if p.ResourceInfo != nil {
+		switch p.ResourceInfo.Extension {
+		case "zip", "tar":
+			return p.ResourceInfo.Extension
+		case "gz":
+			if gzipIsTar(p.Outpath) {
+				return "tar.gz"
+			}
+			return "gz"
+		}
+	}
+	return ""
+}
+
+// gzipIsTar gzip 解压后的内容是否是 tar
+func gzipIsTar(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return false
+	}
+	defer gr.Close()
+	_, err = tar.NewReader(gr).Next()
+	return err == nil
+}
+
+// extraction 一次解压的状态
+type extraction struct {
+	// dir 解压目录
+	dir string
+	// overwrite 是否覆盖已经存在的文件
+	overwrite bool
+	// created 本次解压创建的文件，压缩包中重复的文件可以覆盖
+	created map[string]bool
+}
+
+// extractZip 解压 zip
+func extractZip(ctx context.Context, p *PostProcess, ex *extraction) error {
+	zr, err := zip.OpenReader(p.Outpath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	var total, completed int64
+	for _, f := range zr.File {
+		total += int64(f.UncompressedSize64)
+	}
+	p.Progress(0, total)
+	for _, f := range zr.File {
+		if contextDone(ctx) {
+			return ctx.Err()
+		}
+		target, err := archiveJoin(ex.dir, f.Name)
+		if err != nil {
+			return err
+		}
+		mode := f.Mode()
+		if mode.IsDir() {
+			err = extractDir(ex, target)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		r := &extractReader{ctx: ctx, reader: rc, completed: &completed, total: total, progress: p.Progress}
+		switch {
+		case mode&os.ModeSymlink != 0:
+			var link []byte
+			link, err = io.ReadAll(io.LimitReader(r, 4096))
+			if err == nil {
+				err = extractSymlink(ex, target, string(link))
+			}
+		case mode.IsRegular():
+			err = extractFile(ex, target, r, mode.Perm())
+		}
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractTar 解压 tar，gz 为 true 时先解压 gzip
+func extractTar(ctx context.Context, p *PostProcess, ex *extraction, gz bool) error {
+	f, err := os.Open(p.Outpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// tar 无法提前获取解压后的大小，使用读取压缩包的进度
+	var completed int64
+	total := stat.Size()
+	p.Progress(0, total)
+	var src io.Reader = &extractReader{ctx: ctx, reader: f, completed: &completed, total: total, progress: p.Progress}
+	if gz {
+		gr, err := gzip.NewReader(src)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		src = gr
+	}
+
+	tr := tar.NewReader(src)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target, err := archiveJoin(ex.dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = extractDir(ex, target)
+		case tar.TypeReg:
+			err = extractFile(ex, target, tr, hdr.FileInfo().Mode().Perm())
+		case tar.TypeSymlink:
+			err = extractSymlink(ex, target, hdr.Linkname)
+		case tar.TypeLink:
+			err = extractHardlink(ex, target, hdr.Linkname)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extractGzip 解压单个文件的 gz，解压到去掉 .gz 后的文件名，文件名不以 .gz 结尾时使用 gzip 中记录的文件名
+func extractGzip(ctx context.Context, p *PostProcess, ex *extraction) error {
+	f, err := os.Open(p.Outpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// 无法提前获取解压后的大小，使用读取压缩包的进度
+	var completed int64
+	total := stat.Size()
+	p.Progress(0, total)
+	gr, err := gzip.NewReader(&extractReader{ctx: ctx, reader: f, completed: &completed, total: total, progress: p.Progress})
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	base := filepath.Base(p.Outpath)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+	if !strings.EqualFold(filepath.Ext(base), ".gz") {
+		name = filepath.Base(filepath.FromSlash(gr.Name))
+	}
+	if name == "" || name == "." || name == ".." || name == string(filepath.Separator) {
+		return fmt.Errorf("%w: %s has no file name", ErrUnsupportedArchive, base)
+	}
+	target, err := archiveJoin(ex.dir, name)
+	if err != nil {
+		return err
+	}
+	return extractFile(ex, target, gr, 0)
+}
+
+// extractDir 创建解压的目录
+func extractDir(ex *extraction, target string) error {
+	err := checkArchiveParents(ex.dir, target)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Lstat(target)
+	if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%w: %s is a symlink", ErrUnsafeArchivePath, target)
+	}
+	return os.MkdirAll(target, os.ModePerm)
+}
+
+// extractFile 写入解压的文件，已存在的文件先删除再独占创建，不会通过符号链接写入其他位置
+func extractFile(ex *extraction, target string, r io.Reader, perm os.FileMode) error {
+	err := prepareArchiveTarget(ex, target)
+	if err != nil {
+		return err
+	}
+	if perm == 0 {
+		perm = 0644
+	}
+	// O_EXCL 在目标是符号链接时也会失败
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	ex.created[target] = true
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// extractSymlink 创建符号链接，链接目标必须在解压目录内
+// 链接目标中的 .. 只能出现在开头，保证通过其他符号链接解析时也不会超出解压目录
+func extractSymlink(ex *extraction, target, link string) error {
+	unsafe := fmt.Errorf("%w: %s -> %s", ErrUnsafeArchivePath, target, link)
+	if link == "" || filepath.IsAbs(link) || filepath.VolumeName(link) != "" || strings.HasPrefix(link, "/") {
+		return unsafe
+	}
+	cur := filepath.Dir(target)
+	named := false
+	for _, v := range strings.Split(filepath.ToSlash(link), "/") {
+		switch v {
+		case "", ".":
+		case "..":
+			if named || cur == ex.dir {
+				return unsafe
+			}
+			cur = filepath.Dir(cur)
+		default:
+			named = true
+		}
+	}
+	err := prepareArchiveTarget(ex, target)
+	if err != nil {
+		return err
+	}
+	ex.created[target] = true
+	return os.Symlink(link, target)
+}
+
+// extractHardlink 创建硬链接，链接的源文件必须是解压目录内的普通文件
+func extractHardlink(ex *extraction, target, linkname string) error {
+	oldname, err := archiveJoin(ex.dir, linkname)
+	if err != nil {
+		return err
+	}
+	err = checkArchiveParents(ex.dir, oldname)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Lstat(oldname)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%w: %s -> %s is not a regular file", ErrUnsafeArchivePath, target, linkname)
+	}
+	err = prepareArchiveTarget(ex, target)
+	if err != nil {
+		return err
+	}
+	ex.created[target] = true
+	return os.Link(oldname, target)
+}
+
+// prepareArchiveTarget 检查并创建上级目录，目标是符号链接或者目录时返回错误
+// 已经存在的普通文件只有允许覆盖或者是本次解压创建的时候才删除，否则返回 os.ErrExist
+func prepareArchiveTarget(ex *extraction, target string) error {
+	err := checkArchiveParents(ex.dir, target)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(target), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%w: %s already exists and is not a regular file", ErrUnsafeArchivePath, target)
+	}
+	if !ex.overwrite && !ex.created[target] {
+		return &os.PathError{Op: "extract", Path: target, Err: os.ErrExist}
+	}
+	return os.Remove(target)
+}
+
+// checkArchiveParents 检查解压目录和目标之间已经存在的每一级目录，经过符号链接时返回错误
+// 之前解压的符号链接可能指向解压目录以外的位置，不能通过它们写入
+func checkArchiveParents(dir, target string) error {
+	rel, err := filepath.Rel(dir, filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+	cur := dir
+	for _, v := range strings.Split(rel, string(filepath.Separator)) {
+		cur = filepath.Join(cur, v)
+		fi, err := os.Lstat(cur)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s passes through symlink %s", ErrUnsafeArchivePath, target, cur)
+		}
+	}
+	return nil
+}
+
+// archiveJoin 获取压缩包中的文件在解压目录中的路径，路径不在解压目录内时返回错误
+func archiveJoin(dir, name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("%w: %s", ErrUnsafeArchivePath, name)
+	}
+	target := filepath.Join(dir, name)
+	rel, err := filepath.Rel(dir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s", ErrUnsafeArchivePath, name)
+	}
+	return target, nil
+}
+
+// extractReader 统计解压进度并在上下文结束时停止读取
+type extractReader struct {
+	ctx       context.Context
+	reader    io.Reader
+	completed *int64
+	total     int64
+	progress  func(completed, total int64)
+}
+
+// Read 读取数据并报告进度
+func (er *extractReader) Read(p []byte) (int, error) {
+	if err := er.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := er.reader.Read(p)
+	*er.completed += int64(n)
+	er.progress(*er.completed, er.total)
+	return n, err
+}
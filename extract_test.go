@@ -0,0 +1,262 @@
+package rain
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveJoin(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "rain")
+	data := []struct {
+		name string
+		safe bool
+	}{
+		{"a.txt", true},
+		{"sub/a.txt", true},
+		{"sub/../a.txt", true},
+		{"../a.txt", false},
+		{"sub/../../a.txt", false},
+		{"/etc/passwd", false},
+		{"..", false},
+	}
+	for _, v := range data {
+		_, err := archiveJoin(dir, v.name)
+		if (err == nil) != v.safe {
+			t.Fatal(v.name, err)
+		}
+		if err != nil && !errors.Is(err, ErrUnsafeArchivePath) {
+			t.Fatal(v.name, err)
+		}
+	}
+}
+
+// newTestTar 创建测试用的 tar 文件
+func newTestTar(t *testing.T, path string, headers ...*tar.Header) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range headers {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(hdr.Name))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(hdr.Name))
+		}
+	}
+	tw.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractTar(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "bundle.tar")
+	ctl := &control{}
+	p := &PostProcess{Outpath: archive, ctl: ctl}
+	extractor := &Extractor{Dir: filepath.Join(dir, "out"), RemoveArchive: true}
+
+	newTestTar(t, archive,
+		&tar.Header{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "bin/app", Typeflag: tar.TypeReg, Mode: 0755},
+		&tar.Header{Name: "app", Typeflag: tar.TypeSymlink, Linkname: "bin/app"},
+	)
+	err := extractor.Process(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := os.ReadFile(filepath.Join(dir, "out", "app"))
+	if err != nil || string(d) != "bin/app" {
+		t.Fatal("解压内容错误", err)
+	}
+	if fileExist(archive) {
+		t.Fatal("没有删除压缩包")
+	}
+	if ctl.processCompleted != ctl.processTotal || ctl.processTotal == 0 {
+		t.Fatal("解压进度错误", ctl.processCompleted, ctl.processTotal)
+	}
+
+	// 路径超出解压目录
+	unsafe := [][]*tar.Header{
+		{{Name: "../evil", Typeflag: tar.TypeReg}},
+		{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../../evil"}},
+		{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}},
+		{{Name: "hard", Typeflag: tar.TypeLink, Linkname: "../evil"}},
+	}
+	for _, headers := range unsafe {
+		newTestTar(t, archive, headers...)
+		err = extractor.Process(context.Background(), p)
+		if !errors.Is(err, ErrUnsafeArchivePath) {
+			t.Fatal(headers[0].Name, err)
+		}
+	}
+	if fileExist(filepath.Join(dir, "evil")) {
+		t.Fatal("写入了解压目录以外的文件")
+	}
+
+	// 无法识别的格式
+	p.Outpath = filepath.Join(dir, "bundle.bin")
+	err = extractor.Process(context.Background(), p)
+	if !errors.Is(err, ErrUnsupportedArchive) {
+		t.Fatal(err)
+	}
+}
+
+// TestExtractSymlinkEscape 测试不能通过之前解压的符号链接写入解压目录以外的位置
+func TestExtractSymlinkEscape(t *testing.T) {
+	root := t.TempDir()
+	out := filepath.Join(root, "x", "y", "z", "out")
+	archive := filepath.Join(root, "evil.tar")
+	p := &PostProcess{Outpath: archive, ctl: &control{}}
+	extractor := &Extractor{Dir: out}
+
+	newTestTar(t, archive,
+		&tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."},
+		&tar.Header{Name: "a/a/a/b", Typeflag: tar.TypeSymlink, Linkname: "../../.."},
+		&tar.Header{Name: "b/pwned", Typeflag: tar.TypeReg, Mode: 0644},
+	)
+	err := extractor.Process(context.Background(), p)
+	if !errors.Is(err, ErrUnsafeArchivePath) {
+		t.Fatal("没有拒绝经过符号链接的路径", err)
+	}
+	if fileExist(filepath.Join(root, "x", "pwned")) {
+		t.Fatal("写入了解压目录以外的文件")
+	}
+
+	unsafe := [][]*tar.Header{
+		// 链接目标在名称之后使用 ..，通过其他符号链接解析时可能超出解压目录
+		{{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "a/.."}},
+		// 通过符号链接写入文件
+		{
+			{Name: "dirlink", Typeflag: tar.TypeSymlink, Linkname: "sub"},
+			{Name: "dirlink/file", Typeflag: tar.TypeReg},
+		},
+		// 覆盖已经存在的符号链接
+		{
+			{Name: "same", Typeflag: tar.TypeSymlink, Linkname: "sub"},
+			{Name: "same", Typeflag: tar.TypeReg},
+		},
+		// 硬链接的源文件是符号链接
+		{
+			{Name: "soft", Typeflag: tar.TypeSymlink, Linkname: "sub"},
+			{Name: "hard", Typeflag: tar.TypeLink, Linkname: "soft"},
+		},
+	}
+	for _, headers := range unsafe {
+		os.RemoveAll(out)
+		newTestTar(t, archive, headers...)
+		err = extractor.Process(context.Background(), p)
+		if !errors.Is(err, ErrUnsafeArchivePath) {
+			t.Fatal(headers[len(headers)-1].Name, err)
+		}
+	}
+
+	// 开头的 .. 和普通文件的硬链接可以正常解压
+	os.RemoveAll(out)
+	newTestTar(t, archive,
+		&tar.Header{Name: "bin/app", Typeflag: tar.TypeReg, Mode: 0755},
+		&tar.Header{Name: "lib/app", Typeflag: tar.TypeSymlink, Linkname: "../bin/app"},
+		&tar.Header{Name: "app", Typeflag: tar.TypeLink, Linkname: "bin/app"},
+		&tar.Header{Name: "bin/app", Typeflag: tar.TypeReg, Mode: 0755},
+	)
+	err = extractor.Process(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := os.ReadFile(filepath.Join(out, "lib", "app"))
+	if err != nil || string(d) != "bin/app" {
+		t.Fatal("解压内容错误", err)
+	}
+}
+
+func TestExtractGzip(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "notes.txt.gz")
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write([]byte("notes"))
+	gw.Close()
+	if err := os.WriteFile(archive, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctl := &control{}
+	p := &PostProcess{Outpath: archive, ctl: ctl}
+	err := (&Extractor{}).Process(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := os.ReadFile(filepath.Join(dir, "notes.txt"))
+	if err != nil || string(d) != "notes" {
+		t.Fatal("解压内容错误", err)
+	}
+	if ctl.processCompleted != ctl.processTotal || ctl.processTotal == 0 {
+		t.Fatal("解压进度错误", ctl.processCompleted, ctl.processTotal)
+	}
+
+	// 文件名无法判断时根据内容区分 tar.gz 和单个文件的 gz
+	p.Outpath = filepath.Join(dir, "download")
+	p.ResourceInfo = &ResourceInfo{Extension: "gz"}
+	os.WriteFile(p.Outpath, buf.Bytes(), 0644)
+	if f := archiveFormat(p); f != "gz" {
+		t.Fatal(f)
+	}
+	buf.Reset()
+	gw = gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "a", Typeflag: tar.TypeReg})
+	tw.Close()
+	gw.Close()
+	os.WriteFile(p.Outpath, buf.Bytes(), 0644)
+	if f := archiveFormat(p); f != "tar.gz" {
+		t.Fatal(f)
+	}
+}
+
+func TestExtractOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "bundle.tar")
+	target := filepath.Join(dir, "app")
+	os.WriteFile(target, []byte("old"), 0644)
+	newTestTar(t, archive, &tar.Header{Name: "app", Typeflag: tar.TypeReg, Mode: 0644})
+
+	// 默认不覆盖已经存在的文件
+	p := &PostProcess{Outpath: archive, ctl: &control{}}
+	err := (&Extractor{}).Process(context.Background(), p)
+	if !errors.Is(err, os.ErrExist) {
+		t.Fatal(err)
+	}
+	d, _ := os.ReadFile(target)
+	if string(d) != "old" {
+		t.Fatal("覆盖了已经存在的文件")
+	}
+
+	// 下载设置了 AllowOverwrite
+	p.ctl = &control{config: &Config{AllowOverwrite: true}}
+	err = (&Extractor{}).Process(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ = os.ReadFile(target)
+	if string(d) != "app" {
+		t.Fatal("没有覆盖已经存在的文件")
+	}
+
+	os.WriteFile(target, []byte("old"), 0644)
+	p.ctl = &control{}
+	err = (&Extractor{Overwrite: true}).Process(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ = os.ReadFile(target)
+	if string(d) != "app" {
+		t.Fatal("没有覆盖已经存在的文件")
+	}
+}
@@ -0,0 +1,282 @@
+package rain
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FilenameResolver 根据资源探测信息获取文件名，没有设置输出文件名时使用
+// 返回空字符串时使用默认的规则获取文件名
+type FilenameResolver interface {
+	ResolveFilename(info *ResourceInfo) string
+}
+
+// FilenameResolverFunc 使用函数实现 FilenameResolver
+type FilenameResolverFunc func(info *ResourceInfo) string
+
+// ResolveFilename 获取文件名
+func (f FilenameResolverFunc) ResolveFilename(info *ResourceInfo) string {
+	return f(info)
+}
+
+// DefaultFilenameResolver 默认的文件名获取规则
+// 依次从 Content-Disposition、跳转后的链接、原始链接中获取文件名，没有后缀时根据魔数和 Content-Type 补全后缀
+var DefaultFilenameResolver FilenameResolver = FilenameResolverFunc(func(info *ResourceInfo) string {
+	return info.getFilename()
+})
+
+// contentTypeExtensions 常见资源类型对应的后缀，mime 包中一个类型可能对应多个后缀，优先使用这里的后缀
+var contentTypeExtensions = map[string]string{
+	"application/gzip":             "gz",
+	"application/json":             "json",
+	"application/msword":           "doc",
+	"application/pdf":              "pdf",
+	"application/vnd.rar":          "rar",
+	"application/x-7z-compressed":  "7z",
+	"application/x-bzip2":          "bz2",
+	"application/x-gzip":           "gz",
+	"application/x-rar-compressed": "rar",
+	"application/x-tar":            "tar",
+	"application/x-xz":             "xz",
+	"application/xml":              "xml",
+	"application/zip":              "zip",
+	"audio/flac":                   "flac",
+	"audio/mp4":                    "m4a",
+	"audio/mpeg":                   "mp3",
+	"audio/ogg":                    "ogg",
+	"audio/wav":                    "wav",
+	"image/bmp":                    "bmp",
+	"image/gif":                    "gif",
+	"image/jpeg":                   "jpg",
+	"image/png":                    "png",
+	"image/svg+xml":                "svg",
+	"image/webp":                   "webp",
+	"text/css":                     "css",
+	"text/csv":                     "csv",
+	"text/html":                    "html",
+	"text/javascript":              "js",
+	"text/plain":                   "txt",
+	"text/xml":                     "xml",
+	"video/mp2t":                   "ts",
+	"video/mp4":                    "mp4",
+	"video/quicktime":              "mov",
+	"video/webm":                   "webm",
+	"video/x-flv":                  "flv",
+	"video/x-matroska":             "mkv",
+}
+
+// genericContentTypes 不能表示文件类型的 Content-Type
+var genericContentTypes = map[string]bool{
+	"application/octet-stream":   true,
+	"application/x-download":     true,
+	"application/force-download": true,
+	"binary/octet-stream":        true,
+}
+
+// percentEncoded 是否包含百分号编码
+var percentEncoded = regexp.MustCompile(`%[0-9a-fA-F]{2}`)
+
+// getMimeFilename 获取 Content-Disposition 中的文件名
+// 按照 RFC 6266 优先使用 filename*，支持 RFC 5987 的 UTF-8 和 ISO-8859-1 编码
+func getMimeFilename(s string) string {
+	params, ok := parseDisposition(s)
+	if !ok {
+		return ""
+	}
+	if val, ok := params["filename*"]; ok {
+		if name, ok := decodeExtValue(val); ok && name != "" {
+			return baseFilename(name)
+		}
+	}
+	name := params["filename"]
+	// 部分服务器直接使用百分号编码的 UTF-8 文件名
+	if percentEncoded.MatchString(name) {
+		if v, err := url.PathUnescape(name); err == nil && utf8.ValidString(v) {
+			name = v
+		}
+	}
+	return baseFilename(name)
+}
+
+// parseDisposition 解析 Content-Disposition，返回小写的参数名和参数值
+// 比 mime.ParseMediaType 宽松，参数值中可以包含没有引号的空格
+func parseDisposition(s string) (map[string]string, bool) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexByte(s, ';')
+	if i < 0 {
+		i = len(s)
+	}
+	dispositionType := strings.TrimSpace(s[:i])
+	if dispositionType == "" || strings.ContainsAny(dispositionType, "=\" ") {
+		return nil, false
+	}
+	params := make(map[string]string)
+	s = s[i:]
+	for len(s) > 0 {
+		// 跳过分隔符
+		s = strings.TrimLeft(s, "; \t")
+		if s == "" {
+			break
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		var val string
+		if strings.HasPrefix(s, `"`) {
+			val, s = parseQuoted(s)
+		} else {
+			end := strings.IndexByte(s, ';')
+			if end < 0 {
+				end = len(s)
+			}
+			val, s = strings.TrimSpace(s[:end]), s[end:]
+		}
+		if _, ok := params[key]; !ok && key != "" {
+			params[key] = val
+		}
+	}
+	return params, true
+}
+
+// parseQuoted 解析引号中的字符串，返回字符串和剩余的内容
+func parseQuoted(s string) (string, string) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), ""
+}
+
+// decodeExtValue 解码 RFC 5987 格式的参数值，例如 UTF-8'zh'%E4%B8%AD.txt
+func decodeExtValue(s string) (string, bool) {
+	parts := strings.SplitN(s, "'", 3)
+	if len(parts) != 3 {
+		return "", false
+	}
+	value, err := url.PathUnescape(parts[2])
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(parts[0]) {
+	case "utf-8", "us-ascii":
+		if !utf8.ValidString(value) {
+			return "", false
+		}
+		return value, true
+	case "iso-8859-1":
+		// ISO-8859-1 的每个字节就是对应的 Unicode 码点
+		runes := make([]rune, len(value))
+		for i := 0; i < len(value); i++ {
+			runes[i] = rune(value[i])
+		}
+		return string(runes), true
+	}
+	return "", false
+}
+
+// getUriFilename 获取资源链接中的文件名，解码百分号编码
+func getUriFilename(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return ""
+	}
+	p := u.EscapedPath()
+	if !strings.Contains(p, "/") {
+		return ""
+	}
+	name := p[strings.LastIndexByte(p, '/')+1:]
+	if v, err := url.PathUnescape(name); err == nil {
+		name = v
+	}
+	return baseFilename(name)
+}
+
+// baseFilename 去掉文件名中的路径，避免写入到输出目录之外
+func baseFilename(name string) string {
+	name = strings.TrimSpace(name)
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// contentTypeExtension 根据 Content-Type 获取后缀，不包含点
+func contentTypeExtension(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || genericContentTypes[mediaType] {
+		return ""
+	}
+	if ext, ok := contentTypeExtensions[mediaType]; ok {
+		return ext
+	}
+	exts, _ := mime.ExtensionsByType(mediaType)
+	if len(exts) > 0 {
+		return strings.TrimPrefix(exts[0], ".")
+	}
+	return ""
+}
+
+// getFilename 获取文件名，获取不到时返回空字符串
+func (b *ResourceInfo) getFilename() (name string) {
+	// 从附加信息中获取文件名
+	name = getMimeFilename(b.ContentDisposition)
+	// 从跳转后的链接中获取文件名
+	if name == "" && b.FinalURI != "" {
+		name = getUriFilename(b.FinalURI)
+	}
+	// 从资源链接中获取文件名
+	if name == "" {
+		name = getUriFilename(b.URI)
+	}
+	if name == "" {
+		return ""
+	}
+	// 如果获取的名称没有后缀，优先使用魔数里获取到的后缀，其次使用 Content-Type 对应的后缀
+	if filepath.Ext(name) == "" {
+		ext := b.Extension
+		if ext == "" {
+			ext = contentTypeExtension(b.ContentType)
+		}
+		if ext != "" {
+			name = name + "." + ext
+		}
+	}
+	return name
+}
+
+// resolveFilename 获取文件名，自定义的规则获取不到时使用默认的规则，都获取不到时随机生成名称
+func (r *request) resolveFilename(info *ResourceInfo) string {
+	var name string
+	if r.filenameResolver != nil {
+		name = r.filenameResolver.ResolveFilename(info)
+	}
+	if name == "" {
+		name = info.getFilename()
+	}
+	if name == "" {
+		name = fmt.Sprintf("file_%s%d", randomString(5, 1), time.Now().UnixNano())
+	}
+	return name
+}
@@ -0,0 +1,68 @@
+package rain
+
+import "testing"
+
+// TestParseMimeFilename 测试 Content-Disposition 中的文件名
+func TestParseMimeFilename(t *testing.T) {
+	testData := []struct {
+		disposition string
+		name        string
+	}{
+		{`attachment; filename="file.txt"`, "file.txt"},
+		{`attachment; filename="a \"b\".txt"`, `a "b".txt`},
+		{`attachment; filename=my file.txt`, "my file.txt"},
+		{`attachment; filename="fallback.txt"; filename*=UTF-8''%E4%B8%AD%E6%96%87.txt`, "中文.txt"},
+		{`attachment; filename*=utf-8'zh'%E4%B8%AD.txt; filename="fallback.txt"`, "中.txt"},
+		{`attachment; filename*=iso-8859-1'en'%E9t%E9.txt`, "été.txt"},
+		{`attachment; filename*=gbk''%D6%D0.txt; filename="fallback.txt"`, "fallback.txt"},
+		{`attachment; filename=%E4%B8%AD%E6%96%87.txt`, "中文.txt"},
+		{`attachment; filename="../../etc/passwd"`, "passwd"},
+		{`attachment; filename=".."`, ""},
+		{`attachment`, ""},
+		{`filename=file.txt`, ""},
+	}
+	for _, v := range testData {
+		if name := getMimeFilename(v.disposition); name != v.name {
+			t.Errorf("%s: %q != %q", v.disposition, name, v.name)
+		}
+	}
+}
+
+// TestResolveFilename 测试文件名获取规则
+func TestResolveFilename(t *testing.T) {
+	testData := []struct {
+		info ResourceInfo
+		name string
+	}{
+		{ResourceInfo{URI: "http://example.com/%E4%B8%AD%E6%96%87.zip"}, "中文.zip"},
+		{ResourceInfo{URI: "http://example.com/a%2Fb.zip"}, "b.zip"},
+		{ResourceInfo{URI: "http://example.com/download?id=1", FinalURI: "http://cdn.example.com/files/app.zip"}, "app.zip"},
+		{ResourceInfo{URI: "http://example.com/app", FinalURI: "http://cdn.example.com/"}, "app"},
+		{ResourceInfo{URI: "http://example.com/cover", ContentType: "image/jpeg"}, "cover.jpg"},
+		{ResourceInfo{URI: "http://example.com/cover", ContentType: "image/jpeg", Extension: "png"}, "cover.png"},
+		{ResourceInfo{URI: "http://example.com/data", ContentType: "application/octet-stream"}, "data"},
+		{ResourceInfo{URI: "http://example.com/report", ContentType: "application/pdf; charset=binary"}, "report.pdf"},
+	}
+	for _, v := range testData {
+		if name := v.info.getFilename(); name != v.name {
+			t.Errorf("%s: %q != %q", v.info.URI, name, v.name)
+		}
+	}
+
+	r := &request{}
+	if name := r.resolveFilename(&ResourceInfo{URI: "http://example.com/"}); name == "" {
+		t.Fatal("没有随机生成文件名")
+	}
+	r.filenameResolver = FilenameResolverFunc(func(info *ResourceInfo) string {
+		if info.ContentType == "video/mp4" {
+			return "video.mp4"
+		}
+		return ""
+	})
+	if name := r.resolveFilename(&ResourceInfo{URI: "http://example.com/v", ContentType: "video/mp4"}); name != "video.mp4" {
+		t.Fatal("自定义规则错误", name)
+	}
+	if name := r.resolveFilename(&ResourceInfo{URI: "http://example.com/v.txt"}); name != "v.txt" {
+		t.Fatal("没有使用默认规则", name)
+	}
+}
@@ -0,0 +1,154 @@
+package rain
+
+import (
+	"net/http"
+)
+
+// Hook 下载生命周期钩子，按照注册的顺序依次执行，返回错误时停止下载
+// 只需要部分钩子时可以使用 HookFuncs
+type Hook interface {
+	// BeforeProbe 探测资源之前执行，可以修改 URI 和 Header
+	BeforeProbe(h *HookContext) error
+	// AfterProbe 探测资源之后、打开输出文件之前执行，可以修改 Outdir 和 Outname，返回错误时取消下载
+	AfterProbe(h *HookContext) error
+	// BeforeRequest 每个下载块发送请求之前执行，可以修改请求
+	BeforeRequest(h *HookContext, req *http.Request) error
+	// AfterFinish 下载结束之后执行，手动 Close 时不执行
+	// err 为下载的错误，返回值作为新的下载结果，可以移动、校验或者转换文件，移动文件时需要修改 Outpath
+	AfterFinish(h *HookContext, err error) error
+}
+
+// HookContext 钩子执行时的下载信息
+type HookContext struct {
+	// URI 下载链接
+	URI string
+	// Header 请求头，每次执行钩子时都是副本，只有 BeforeProbe 中的修改会应用到下载
+	// BeforeRequest 中修改请求头需要修改 req.Header
+	Header http.Header
+	// ResourceInfo 资源探测信息，AfterProbe 开始有效
+	ResourceInfo *ResourceInfo
+	// Outdir 输出目录
+	Outdir string
+	// Outname 输出文件名，AfterProbe 中为空时使用探测到的文件名
+	Outname string
+	// Outpath 输出文件路径，AfterFinish 中有效
+	Outpath string
+}
+
+// HookFuncs 使用函数实现 Hook，为 nil 的函数不执行
+type HookFuncs struct {
+	BeforeProbeFunc   func(h *HookContext) error
+	AfterProbeFunc    func(h *HookContext) error
+	BeforeRequestFunc func(h *HookContext, req *http.Request) error
+	AfterFinishFunc   func(h *HookContext, err error) error
+}
+
+var _ Hook = &HookFuncs{}
+
+// BeforeProbe 探测资源之前执行
+func (hf *HookFuncs) BeforeProbe(h *HookContext) error {
+	if hf.BeforeProbeFunc == nil {
+		return nil
+	}
+	return hf.BeforeProbeFunc(h)
+}
+
+// AfterProbe 探测资源之后执行
+func (hf *HookFuncs) AfterProbe(h *HookContext) error {
+	if hf.AfterProbeFunc == nil {
+		return nil
+	}
+	return hf.AfterProbeFunc(h)
+}
+
+// BeforeRequest 下载块发送请求之前执行
+func (hf *HookFuncs) BeforeRequest(h *HookContext, req *http.Request) error {
+	if hf.BeforeRequestFunc == nil {
+		return nil
+	}
+	return hf.BeforeRequestFunc(h, req)
+}
+
+// AfterFinish 下载结束之后执行
+func (hf *HookFuncs) AfterFinish(h *HookContext, err error) error {
+	if hf.AfterFinishFunc == nil {
+		return err
+	}
+	return hf.AfterFinishFunc(h, err)
+}
+
+// addHook 新增钩子
+func (ctl *control) addHook(h ...Hook) {
+	ctl.hooks = append(ctl.hooks, h...)
+}
+
+// hookContext 获取钩子使用的下载信息
+// BeforeRequest 会在多个下载块中同时执行，请求头使用副本避免共享
+func (ctl *control) hookContext() *HookContext {
+	return &HookContext{
+		URI:          ctl.uri,
+		Header:       ctl.request.header.Clone(),
+		ResourceInfo: ctl.resourceInfo,
+		Outdir:       ctl.outdir,
+		Outname:      ctl.outname,
+		Outpath:      ctl.outpath,
+	}
+}
+
+// hookBeforeProbe 执行 BeforeProbe 钩子，应用修改后的链接和请求头
+func (ctl *control) hookBeforeProbe() error {
+	if len(ctl.hooks) == 0 {
+		return nil
+	}
+	h := ctl.hookContext()
+	for _, v := range ctl.hooks {
+		if err := v.BeforeProbe(h); err != nil {
+			return err
+		}
+	}
+	ctl.uri, ctl.request.uri = h.URI, h.URI
+	ctl.request.header = h.Header
+	return nil
+}
+
+// hookAfterProbe 执行 AfterProbe 钩子，应用修改后的输出目录和文件名
+func (ctl *control) hookAfterProbe() error {
+	if len(ctl.hooks) == 0 {
+		return nil
+	}
+	h := ctl.hookContext()
+	if h.Outname == "" {
+		h.Outname = ctl.resourceInfo.Filename
+	}
+	for _, v := range ctl.hooks {
+		if err := v.AfterProbe(h); err != nil {
+			return err
+		}
+	}
+	ctl.outdir, ctl.outname = h.Outdir, h.Outname
+	return nil
+}
+
+// hookBeforeRequest 执行 BeforeRequest 钩子
+func (ctl *control) hookBeforeRequest(req *http.Request) error {
+	h := ctl.hookContext()
+	for _, v := range ctl.hooks {
+		if err := v.BeforeRequest(h, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hookAfterFinish 执行 AfterFinish 钩子，应用修改后的输出路径
+func (ctl *control) hookAfterFinish(err error) error {
+	if len(ctl.hooks) == 0 {
+		return err
+	}
+	h := ctl.hookContext()
+	for _, v := range ctl.hooks {
+		err = v.AfterFinish(h, err)
+	}
+	ctl.outpath = h.Outpath
+	return err
+}
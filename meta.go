@@ -0,0 +1,65 @@
+package rain
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// fileMeta 同步模式下保存在输出文件旁边的资源信息，用于判断资源是否发生变化
+type fileMeta struct {
+	// URI 资源链接
+	URI string `json:"uri"`
+	// Filesize 资源大小
+	Filesize int64 `json:"filesize"`
+	// Etag 资源唯一标识
+	Etag string `json:"etag"`
+	// LastModified 资源最后修改时间
+	LastModified string `json:"last_modified"`
+}
+
+// loadFileMeta 加载资源信息
+func loadFileMeta(path string) (*fileMeta, error) {
+	d, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var meta fileMeta
+	err = json.Unmarshal(d, &meta)
+	if err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// export 导出
+func (meta *fileMeta) export(path string, perm os.FileMode) error {
+	d, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, d, perm)
+}
+
+// unchanged 探测到的资源与保存的资源信息是否相同
+// 优先比较 etag，没有 etag 时比较 Last-Modified，都没有时视为已经变化
+func (meta *fileMeta) unchanged(resInfo *ResourceInfo) bool {
+	if resInfo.Filesize >= 0 && resInfo.Filesize != meta.Filesize {
+		return false
+	}
+	if meta.Etag != "" && resInfo.Etag != "" {
+		return meta.Etag == resInfo.Etag
+	}
+	if meta.LastModified != "" && resInfo.LastModified != "" {
+		return meta.LastModified == resInfo.LastModified
+	}
+	return false
+}
+
+// matchFile 本地文件大小是否与保存的资源信息相同
+func (meta *fileMeta) matchFile(path string) bool {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return stat.Size() == meta.Filesize
+}
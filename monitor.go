@@ -0,0 +1,139 @@
+package rain
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// speedMonitor 下载块的低速检测，速度在检测窗口内持续低于限制时取消下载块的请求
+type speedMonitor struct {
+	// limit 每秒最低字节数
+	limit int
+	// window 检测窗口
+	window time.Duration
+	// cancel 取消下载块的请求
+	cancel context.CancelFunc
+	// count 当前窗口内接收的字节数
+	count int64
+	// stalled 是否因为低速被取消
+	stalled int32
+}
+
+// newSpeedMonitor 创建低速检测，未设置低速限制时返回 nil
+func (ctl *control) newSpeedMonitor(cancel context.CancelFunc) *speedMonitor {
+	if ctl.config.LowSpeedLimit <= 0 || ctl.config.LowSpeedTime <= 0 {
+		return nil
+	}
+	return &speedMonitor{
+		limit:  ctl.config.LowSpeedLimit,
+		window: ctl.config.LowSpeedTime,
+		cancel: cancel,
+	}
+}
+
+// run 开始检测，直到上下文结束或者检测到低速
+func (m *speedMonitor) run(ctx context.Context) {
+	ticker := time.NewTicker(m.window)
+	defer ticker.Stop()
+	minimum := int64(float64(m.limit) * m.window.Seconds())
+	for {
+		select {
+		case <-ticker.C:
+			if atomic.SwapInt64(&m.count, 0) < minimum {
+				atomic.StoreInt32(&m.stalled, 1)
+				m.cancel()
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reader 包装需要统计接收字节数的 reader
+func (m *speedMonitor) reader(r io.Reader) io.Reader {
+	return &monitorReader{reader: r, monitor: m}
+}
+
+// isStalled 是否因为低速被取消
+func (m *speedMonitor) isStalled() bool {
+	return m != nil && atomic.LoadInt32(&m.stalled) == 1
+}
+
+// monitorReader 统计接收字节数的 reader
+type monitorReader struct {
+	reader  io.Reader
+	monitor *speedMonitor
+}
+
+// Read 读取并统计字节数
+func (mr *monitorReader) Read(p []byte) (int, error) {
+	n, err := mr.reader.Read(p)
+	atomic.AddInt64(&mr.monitor.count, int64(n))
+	return n, err
+}
+
+// countReader 统计接收字节数的 reader
+type countReader struct {
+	reader io.Reader
+	count  *int64
+}
+
+// newCountReader 创建统计接收字节数的 reader
+func newCountReader(r io.Reader, count *int64) *countReader {
+	return &countReader{reader: r, count: count}
+}
+
+// Read 读取并统计字节数
+func (cr *countReader) Read(p []byte) (int, error) {
+	n, err := cr.reader.Read(p)
+	atomic.AddInt64(cr.count, int64(n))
+	return n, err
+}
+
+// idleReader 读取空闲超时检测，超过 timeout 没有读取到数据时取消下载块的请求
+type idleReader struct {
+	reader  io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	// idle 是否因为空闲超时被取消
+	idle int32
+}
+
+// newIdleReader 创建读取空闲超时检测，timeout 小于等于 0 时返回 nil
+func newIdleReader(r io.Reader, timeout time.Duration, cancel context.CancelFunc) *idleReader {
+	if timeout <= 0 {
+		return nil
+	}
+	ir := &idleReader{reader: r, timeout: timeout}
+	ir.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&ir.idle, 1)
+		cancel()
+	})
+	return ir
+}
+
+// Read 读取数据并重置计时
+func (ir *idleReader) Read(p []byte) (int, error) {
+	n, err := ir.reader.Read(p)
+	if err != nil {
+		ir.timer.Stop()
+	} else if !ir.isIdle() {
+		ir.timer.Reset(ir.timeout)
+	}
+	return n, err
+}
+
+// stop 停止计时
+func (ir *idleReader) stop() {
+	if ir != nil {
+		ir.timer.Stop()
+	}
+}
+
+// isIdle 是否因为空闲超时被取消
+func (ir *idleReader) isIdle() bool {
+	return ir != nil && atomic.LoadInt32(&ir.idle) == 1
+}
@@ -1,10 +1,13 @@
 package rain
 
 import (
+	"context"
+	"crypto/tls"
 	"io"
 	"io/fs"
 	"net/http"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -36,6 +39,14 @@ func WithOutpath(outpath string) OptionFunc {
 	}
 }
 
+// WithOutTemplate 输出路径模板，没有设置输出文件名时根据资源信息计算相对于输出目录的路径
+// 例如 {{.Host}}/{{.Date}}/{{.Name}}{{.Ext}}，可以使用的字段见 OutTemplateData
+func WithOutTemplate(tmpl string) OptionFunc {
+	return func(ctl *control) {
+		ctl.config.OutTemplate = tmpl
+	}
+}
+
 // WithEvent 事件监听
 func WithEvent(e ...ProgressEvent) OptionFunc {
 	return func(ctl *control) {
@@ -50,6 +61,168 @@ func WithEventExtend(e ...ProgressEventExtend) OptionFunc {
 	}
 }
 
+// WithRequestSigner 请求签名，每次发送请求之前执行，请求返回 401 或 403 时重新签名
+func WithRequestSigner(s ...RequestSigner) OptionFunc {
+	return func(ctl *control) {
+		ctl.request.signers = append(ctl.request.signers, s...)
+	}
+}
+
+// WithFilenameResolver 自定义没有设置输出文件名时获取文件名的规则，返回空字符串时使用默认的规则
+func WithFilenameResolver(resolver FilenameResolver) OptionFunc {
+	return func(ctl *control) {
+		ctl.request.filenameResolver = resolver
+	}
+}
+
+// WithCookieJar 当前下载使用的 cookie jar
+func WithCookieJar(jar http.CookieJar) OptionFunc {
+	return func(ctl *control) {
+		ctl.setCookieJar(jar)
+	}
+}
+
+// WithCookieFile 加载 Netscape cookies.txt 格式的 cookie 文件，save 为 true 时下载结束后保存更新的 cookie
+func WithCookieFile(path string, save bool) OptionFunc {
+	return func(ctl *control) {
+		ctl.cookieFile = path
+		ctl.cookieSave = save
+	}
+}
+
+// WithMaxRedirects 最多跳转次数
+func WithMaxRedirects(d int) OptionFunc {
+	return func(ctl *control) {
+		ctl.config.MaxRedirects = d
+	}
+}
+
+// WithRedirectPolicy 跳转策略，按照添加的顺序执行，例如 DenyDowngrade、AllowRedirectDomains
+func WithRedirectPolicy(p ...RedirectPolicy) OptionFunc {
+	return func(ctl *control) {
+		ctl.redirectPolicies = append(ctl.redirectPolicies, p...)
+	}
+}
+
+// WithLocalAddr 绑定建立连接时使用的本地 IP 地址
+func WithLocalAddr(ip string) OptionFunc {
+	return func(ctl *control) {
+		ctl.dialSettings().localAddr = ip
+	}
+}
+
+// WithInterface 绑定建立连接时使用的网卡，使用网卡上与目标地址类型相同的第一个地址
+func WithInterface(name string) OptionFunc {
+	return func(ctl *control) {
+		ctl.dialSettings().iface = name
+	}
+}
+
+// WithResolve 静态域名解析，与 curl 的 --resolve 相同，host 可以带端口，例如 example.com:443
+func WithResolve(host string, ips ...string) OptionFunc {
+	return func(ctl *control) {
+		ctl.dialSettings().hosts[strings.ToLower(host)] = ips
+	}
+}
+
+// WithResolver 自定义域名解析，静态域名解析优先
+func WithResolver(r Resolver) OptionFunc {
+	return func(ctl *control) {
+		ctl.dialSettings().resolver = r
+	}
+}
+
+// WithProtocol 下载使用的 HTTP 协议
+func WithProtocol(d Protocol) OptionFunc {
+	return func(ctl *control) {
+		ctl.config.Protocol = d
+	}
+}
+
+// WithProxy 当前下载使用的代理，支持 http、https、socks5 和 socks5h，认证信息写在链接中
+// 多个代理时每个请求轮流使用，例如不同的下载块使用不同的代理
+func WithProxy(proxies ...string) OptionFunc {
+	return func(ctl *control) {
+		ctl.proxies = append(ctl.proxies, proxies...)
+	}
+}
+
+// WithTLSConfig 当前下载使用的 TLS 配置，其他 TLS option 在此基础上修改
+func WithTLSConfig(cfg *tls.Config) OptionFunc {
+	return func(ctl *control) {
+		ctl.tlsSettings().config = cfg
+	}
+}
+
+// WithCACertFile 在系统证书的基础上添加 PEM 格式的 CA 证书
+func WithCACertFile(path string) OptionFunc {
+	return func(ctl *control) {
+		s := ctl.tlsSettings()
+		s.caFiles = append(s.caFiles, path)
+	}
+}
+
+// WithClientCert 使用 PEM 格式的客户端证书和私钥进行双向认证
+func WithClientCert(certFile, keyFile string) OptionFunc {
+	return func(ctl *control) {
+		s := ctl.tlsSettings()
+		s.certFile, s.keyFile = certFile, keyFile
+	}
+}
+
+// WithTLSMinVersion 最低 TLS 版本，例如 tls.VersionTLS12
+func WithTLSMinVersion(v uint16) OptionFunc {
+	return func(ctl *control) {
+		ctl.tlsSettings().minVersion = v
+	}
+}
+
+// WithPinnedSPKI 固定服务器证书的公钥，值为证书 SubjectPublicKeyInfo 的 sha256 base64，可以带 sha256/ 前缀
+// 证书链中没有匹配的公钥时返回 ErrPinMismatch
+func WithPinnedSPKI(pins ...string) OptionFunc {
+	return func(ctl *control) {
+		s := ctl.tlsSettings()
+		s.pins = append(s.pins, pins...)
+	}
+}
+
+// WithInsecureSkipVerify 跳过服务器证书验证，只用于无法验证证书的旧服务器
+func WithInsecureSkipVerify(d bool) OptionFunc {
+	return func(ctl *control) {
+		ctl.tlsSettings().insecure = &d
+	}
+}
+
+// WithBasicAuth 使用 Basic 认证
+func WithBasicAuth(username, password string) OptionFunc {
+	return WithRequestSigner(&basicAuth{username: username, password: password})
+}
+
+// WithBearerTokenSource 使用 Bearer 认证，首次请求时从 source 获取 token，请求返回 401 或 403 时重新获取
+func WithBearerTokenSource(source func(ctx context.Context) (string, error)) OptionFunc {
+	return WithRequestSigner(&bearerToken{source: source})
+}
+
+// WithNetrc 根据请求的主机名使用 .netrc 中的认证信息
+// path 为空时使用 NETRC 环境变量或者用户目录下的 .netrc
+func WithNetrc(path string) OptionFunc {
+	return WithRequestSigner(&netrcAuth{path: path})
+}
+
+// WithHook 下载生命周期钩子
+func WithHook(h ...Hook) OptionFunc {
+	return func(ctl *control) {
+		ctl.addHook(h...)
+	}
+}
+
+// WithPostProcessor 下载完成后的处理步骤，按照添加的顺序依次执行
+func WithPostProcessor(p ...PostProcessor) OptionFunc {
+	return func(ctl *control) {
+		ctl.addPostProcessor(p...)
+	}
+}
+
 // WithBar 进度条
 func WithBar(bars ...*Bar) OptionFunc {
 	return func(ctl *control) {
@@ -115,6 +288,13 @@ func WithRoutineCount(d int) OptionFunc {
 	}
 }
 
+// WithRoutineAuto 设置是否根据下载速度自动调整协程数量，协程最大数为上限
+func WithRoutineAuto(d bool) OptionFunc {
+	return func(ctl *control) {
+		ctl.config.RoutineAuto = d
+	}
+}
+
 // WithDiskCache 设置磁盘缓冲区大小
 func WithDiskCache(d int) OptionFunc {
 	return func(ctl *control) {
@@ -129,6 +309,35 @@ func WithSpeedLimit(d int) OptionFunc {
 	}
 }
 
+// WithSpeedBurst 设置限速器允许的突发字节数
+func WithSpeedBurst(d int) OptionFunc {
+	return func(ctl *control) {
+		ctl.config.SpeedBurst = d
+	}
+}
+
+// WithSpeedSchedule 设置分时段限速计划
+func WithSpeedSchedule(d *SpeedSchedule) OptionFunc {
+	return func(ctl *control) {
+		ctl.config.SpeedSchedule = d
+	}
+}
+
+// WithLowSpeedLimit 设置低速检测，下载块在 window 内速度持续低于 bytesPerSec 时重试，重试后仍然低速返回 ErrStalled
+func WithLowSpeedLimit(bytesPerSec int, window time.Duration) OptionFunc {
+	return func(ctl *control) {
+		ctl.config.LowSpeedLimit = bytesPerSec
+		ctl.config.LowSpeedTime = window
+	}
+}
+
+// WithProbeHead 设置探测资源时是否先尝试 HEAD 请求
+func WithProbeHead(d bool) OptionFunc {
+	return func(ctl *control) {
+		ctl.config.ProbeHead = d
+	}
+}
+
 // WithCreateDir 设置是否可以创建目录
 func WithCreateDir(d bool) OptionFunc {
 	return func(ctl *control) {
@@ -157,6 +366,20 @@ func WithAutoFileRenaming(d bool) OptionFunc {
 	}
 }
 
+// WithRenameStrategy 设置自动重命名的规则
+func WithRenameStrategy(d RenameStrategy) OptionFunc {
+	return func(ctl *control) {
+		ctl.config.RenameStrategy = d
+	}
+}
+
+// WithRenameMaxAttempts 设置自动重命名的最多次数，超过后返回 *RenameError
+func WithRenameMaxAttempts(d int) OptionFunc {
+	return func(ctl *control) {
+		ctl.config.RenameMaxAttempts = d
+	}
+}
+
 // WithAutoFilterFilename 设置是否自动过滤掉文件名称中的非法字符
 func WithAutoFilterFilename(d bool) OptionFunc {
 	return func(ctl *control) {
@@ -164,13 +387,48 @@ func WithAutoFilterFilename(d bool) OptionFunc {
 	}
 }
 
-// WithTimeout 设置下载超时时间
+// WithFilenamePolicy 设置过滤文件名称时使用的规则
+func WithFilenamePolicy(d FilenamePolicy) OptionFunc {
+	return func(ctl *control) {
+		ctl.config.FilenamePolicy = d
+	}
+}
+
+// WithFilenameReplacement 设置替换文件名称中非法字符的字符串，为空时直接删除非法字符
+func WithFilenameReplacement(d string) OptionFunc {
+	return func(ctl *control) {
+		ctl.config.FilenameReplacement = d
+	}
+}
+
+// WithTimeout 设置下载总超时时间，暂停的时间不计入
 func WithTimeout(d time.Duration) OptionFunc {
 	return func(ctl *control) {
 		ctl.config.Timeout = d
 	}
 }
 
+// WithConnectTimeout 设置建立连接的超时时间
+func WithConnectTimeout(d time.Duration) OptionFunc {
+	return func(ctl *control) {
+		ctl.config.ConnectTimeout = d
+	}
+}
+
+// WithResponseHeaderTimeout 设置建立连接后等待响应头的超时时间
+func WithResponseHeaderTimeout(d time.Duration) OptionFunc {
+	return func(ctl *control) {
+		ctl.config.ResponseHeaderTimeout = d
+	}
+}
+
+// WithIdleTimeout 设置读取数据时的空闲超时时间
+func WithIdleTimeout(d time.Duration) OptionFunc {
+	return func(ctl *control) {
+		ctl.config.IdleTimeout = d
+	}
+}
+
 // WithRetryNumber 设置请求重试次数
 func WithRetryNumber(d int) OptionFunc {
 	return func(ctl *control) {
@@ -191,3 +449,24 @@ func WithBreakpointExt(d string) OptionFunc {
 		ctl.config.BreakpointExt = d
 	}
 }
+
+// WithSyncMode 设置同步模式，输出文件已经存在时只在资源发生变化时重新下载并覆盖
+func WithSyncMode(d bool) OptionFunc {
+	return func(ctl *control) {
+		ctl.config.SyncMode = d
+	}
+}
+
+// WithMetaExt 同步模式下保存资源信息的文件扩展, 默认为 .meta.rain
+func WithMetaExt(d string) OptionFunc {
+	return func(ctl *control) {
+		ctl.config.MetaExt = d
+	}
+}
+
+// WithDecompress 请求压缩传输并将内容解码后写入文件，只使用单协程下载
+func WithDecompress(d bool) OptionFunc {
+	return func(ctl *control) {
+		ctl.config.Decompress = d
+	}
+}
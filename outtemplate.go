@@ -0,0 +1,114 @@
+package rain
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// OutTemplateData 输出路径模板可以使用的资源信息
+type OutTemplateData struct {
+	// Host 资源链接的主机名，不包含端口
+	Host string
+	// Path 资源链接的路径，不包含开头的 /
+	Path string
+	// Dir 资源链接路径中的目录部分，没有目录时为空
+	Dir string
+	// Segments 资源链接路径中的每一段
+	Segments []string
+	// Filename 自动获取的文件名
+	Filename string
+	// Name 不包含扩展名的文件名
+	Name string
+	// Ext 扩展名，包含点
+	Ext string
+	// ContentType 资源类型，不包含参数
+	ContentType string
+	// Size 资源大小，未知时为 -1
+	Size int64
+	// Etag 资源唯一标识，不包含引号
+	Etag string
+	// Date 当前日期，格式为 2006-01-02
+	Date string
+	// Time 当前时间
+	Time time.Time
+}
+
+// newOutTemplateData 根据资源探测信息生成模板数据
+func newOutTemplateData(uri string, info *ResourceInfo, now time.Time) *OutTemplateData {
+	data := &OutTemplateData{
+		Filename: info.Filename,
+		Ext:      filepath.Ext(info.Filename),
+		Size:     info.Filesize,
+		Etag:     strings.Trim(strings.TrimPrefix(info.Etag, "W/"), `"`),
+		Date:     now.Format("2006-01-02"),
+		Time:     now,
+	}
+	data.Name = strings.TrimSuffix(info.Filename, data.Ext)
+	if mediaType, _, err := mime.ParseMediaType(info.ContentType); err == nil {
+		data.ContentType = mediaType
+	}
+	if u, err := url.Parse(uri); err == nil {
+		data.Host = u.Hostname()
+		data.Path = strings.TrimPrefix(u.Path, "/")
+		if dir := path.Dir(data.Path); dir != "." {
+			data.Dir = dir
+		}
+		for _, v := range strings.Split(data.Path, "/") {
+			if v != "" {
+				data.Segments = append(data.Segments, v)
+			}
+		}
+	}
+	return data
+}
+
+// executeOutTemplate 执行输出路径模板，返回相对于输出目录的路径
+// 模板结果按照 / 分割，每一段分别使用 filter 过滤非法字符，忽略空的段和 .、..
+func executeOutTemplate(text string, data *OutTemplateData, filter func(string) string) (string, error) {
+	tmpl, err := template.New("out").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("out template: %w", err)
+	}
+	buf := bytes.NewBuffer(nil)
+	err = tmpl.Execute(buf, data)
+	if err != nil {
+		return "", fmt.Errorf("out template: %w", err)
+	}
+	segments := make([]string, 0)
+	for _, v := range strings.Split(filepath.ToSlash(buf.String()), "/") {
+		v = strings.TrimSpace(v)
+		if v != "" && v != "." && v != ".." && filter != nil {
+			v = filter(v)
+		}
+		if v == "" || v == "." || v == ".." {
+			continue
+		}
+		segments = append(segments, v)
+	}
+	if len(segments) == 0 {
+		return "", fmt.Errorf("out template: %q produced an empty path", text)
+	}
+	return filepath.Join(segments...), nil
+}
+
+// applyOutTemplate 没有设置输出文件名时，使用输出路径模板计算输出目录和文件名
+func (ctl *control) applyOutTemplate(info *ResourceInfo) error {
+	if ctl.config.OutTemplate == "" || ctl.outname != "" {
+		return nil
+	}
+	p, err := executeOutTemplate(ctl.config.OutTemplate, newOutTemplateData(ctl.uri, info, time.Now()), ctl.filterFilename)
+	if err != nil {
+		return err
+	}
+	dir, name := filepath.Split(p)
+	ctl.outdir = filepath.Join(ctl.outdir, dir)
+	ctl.outname = name
+	return nil
+}
@@ -0,0 +1,48 @@
+package rain
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestOutTemplate 测试输出路径模板
+func TestOutTemplate(t *testing.T) {
+	now := time.Date(2022, 5, 1, 8, 0, 0, 0, time.UTC)
+	info := &ResourceInfo{
+		Filename:    "report.pdf",
+		Filesize:    1024,
+		Etag:        `W/"abc"`,
+		ContentType: "application/pdf; charset=binary",
+	}
+	data := newOutTemplateData("http://example.com:8080/docs/2022/report?id=1", info, now)
+	if data.Host != "example.com" || data.Path != "docs/2022/report" || data.Dir != "docs/2022" ||
+		len(data.Segments) != 3 || data.Name != "report" || data.Ext != ".pdf" ||
+		data.ContentType != "application/pdf" || data.Etag != "abc" || data.Date != "2022-05-01" {
+		t.Fatalf("模板数据错误: %#v", data)
+	}
+
+	testData := []struct {
+		tmpl   string
+		filter func(string) string
+		path   string
+		err    bool
+	}{
+		{"{{.Host}}/{{.Date}}/{{.Name}}{{.Ext}}", filterFileName, "example.com/2022-05-01/report.pdf", false},
+		{"{{.Dir}}/{{.Etag}}-{{.Size}}{{.Ext}}", filterFileName, "docs/2022/abc-1024.pdf", false},
+		{"{{index .Segments 0}}/{{.Time.Format \"2006\"}}/{{.Filename}}", filterFileName, "docs/2022/report.pdf", false},
+		{"../../{{.Filename}}", filterFileName, "report.pdf", false},
+		{"{{.ContentType}}/{{.Filename}}", filterFileName, "application/pdf/report.pdf", false},
+		{"a:b/c?d", filterFileName, "ab/cd", false},
+		{"a:b/c?d", nil, "a:b/c?d", false},
+		{"{{.Host}", filterFileName, "", true},
+		{"{{.Unknown}}", filterFileName, "", true},
+		{"//", filterFileName, "", true},
+	}
+	for _, v := range testData {
+		p, err := executeOutTemplate(v.tmpl, data, v.filter)
+		if (err != nil) != v.err || p != filepath.FromSlash(v.path) {
+			t.Errorf("%s: %q %v", v.tmpl, p, err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package rain
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// PostProcessor 下载完成后的处理步骤，按照添加的顺序依次执行，返回错误时停止执行后续步骤
+type PostProcessor interface {
+	Process(ctx context.Context, p *PostProcess) error
+}
+
+// PostProcessorFunc 使用函数实现 PostProcessor
+type PostProcessorFunc func(ctx context.Context, p *PostProcess) error
+
+// Process 执行处理步骤
+func (f PostProcessorFunc) Process(ctx context.Context, p *PostProcess) error {
+	return f(ctx, p)
+}
+
+// PostProcess 下载完成后处理步骤的信息
+type PostProcess struct {
+	// Outpath 下载完成的文件路径
+	Outpath string
+	// ResourceInfo 资源探测信息
+	ResourceInfo *ResourceInfo
+
+	ctl *control
+}
+
+// Progress 报告当前处理步骤的进度，通过进度事件的 ProcessCompleted 和 ProcessTotal 发送
+func (p *PostProcess) Progress(completed, total int64) {
+	atomic.StoreInt64(&p.ctl.processCompleted, completed)
+	atomic.StoreInt64(&p.ctl.processTotal, total)
+}
+
+// addPostProcessor 新增下载完成后的处理步骤
+func (ctl *control) addPostProcessor(p ...PostProcessor) {
+	ctl.postProcessors = append(ctl.postProcessors, p...)
+}
+
+// postProcess 下载成功后依次执行处理步骤
+func (ctl *control) postProcess() error {
+	if len(ctl.postProcessors) == 0 {
+		return nil
+	}
+	ctl.setStatus(STATUS_PROCESSING)
+	p := &PostProcess{
+		Outpath:      ctl.outpath,
+		ResourceInfo: ctl.resourceInfo,
+		ctl:          ctl,
+	}
+	for _, v := range ctl.postProcessors {
+		p.Progress(0, 0)
+		err := v.Process(ctl.ctx, p)
+		if err != nil {
+			return err
+		}
+		if contextDone(ctl.ctx) {
+			return ctl.ctx.Err()
+		}
+	}
+	return nil
+}
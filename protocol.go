@@ -0,0 +1,136 @@
+package rain
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// Protocol 下载使用的 HTTP 协议
+type Protocol int
+
+const (
+	// PROTOCOL_AUTO 使用 transport 的设置，默认的 transport 通过 TLS 协商使用 HTTP/2
+	PROTOCOL_AUTO = Protocol(iota)
+	// PROTOCOL_HTTP1 只使用 HTTP/1.1，每个协程使用单独的连接
+	PROTOCOL_HTTP1
+	// PROTOCOL_HTTP2 尝试通过 TLS 协商使用 HTTP/2，多个协程复用同一个连接
+	PROTOCOL_HTTP2
+)
+
+// needTransport 当前下载是否需要使用复制的 transport，只有 TLS、代理、连接设置或者协议与请求客户端不同时复制
+// 连接池大小由 Rain.SetRoutineCount 在共享的 transport 上设置，不会因为连接池复制 transport
+func (ctl *control) needTransport() bool {
+	if ctl.tls != nil || ctl.dial != nil || len(ctl.proxies) != 0 {
+		return true
+	}
+	if ctl.config.Protocol == PROTOCOL_AUTO {
+		return false
+	}
+	switch v := ctl.request.client.Transport.(type) {
+	case nil:
+		return !protocolMatches(http.DefaultTransport.(*http.Transport), ctl.config.Protocol)
+	case *http.Transport:
+		return !protocolMatches(v, ctl.config.Protocol)
+	}
+	return true
+}
+
+// protocolMatches transport 是否已经使用了协议 p
+func protocolMatches(transport *http.Transport, p Protocol) bool {
+	var nextProtos []string
+	if transport.TLSClientConfig != nil {
+		nextProtos = transport.TLSClientConfig.NextProtos
+	}
+	switch p {
+	case PROTOCOL_HTTP1:
+		return !transport.ForceAttemptHTTP2 && transport.TLSNextProto != nil && len(transport.TLSNextProto) == 0 &&
+			!containsString(nextProtos, "h2")
+	case PROTOCOL_HTTP2:
+		// 使用过的 transport 在 TLSNextProto 和 NextProtos 中加入了 h2
+		return transport.ForceAttemptHTTP2 && (transport.TLSNextProto == nil || transport.TLSNextProto["h2"] != nil) &&
+			(len(nextProtos) == 0 || containsString(nextProtos, "h2"))
+	}
+	return true
+}
+
+// containsString 列表中是否有 v
+func containsString(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// applyProtocol 设置协议
+func (ctl *control) applyProtocol(transport *http.Transport) {
+	setProtocol(transport, ctl.config.Protocol)
+}
+
+// setProtocol 设置 transport 使用的协议，PROTOCOL_AUTO 时不修改
+func setProtocol(transport *http.Transport, p Protocol) {
+	switch p {
+	case PROTOCOL_HTTP1:
+		// TLSNextProto 不为 nil 时不会使用 HTTP/2
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(authority string, c *tls.Conn) http.RoundTripper)
+		// 使用过的 transport 已经在 TLS 协商中加入了 h2
+		if cfg := transport.TLSClientConfig; cfg != nil && len(cfg.NextProtos) > 0 {
+			cfg = cfg.Clone()
+			cfg.NextProtos = nil
+			for _, v := range transport.TLSClientConfig.NextProtos {
+				if v != "h2" {
+					cfg.NextProtos = append(cfg.NextProtos, v)
+				}
+			}
+			transport.TLSClientConfig = cfg
+		}
+	case PROTOCOL_HTTP2:
+		transport.ForceAttemptHTTP2 = true
+		transport.TLSNextProto = nil
+		// 关闭过 HTTP/2 的 transport 在 TLS 协商中没有 h2，清空后重新协商
+		if cfg := transport.TLSClientConfig; cfg != nil && len(cfg.NextProtos) > 0 && !containsString(cfg.NextProtos, "h2") {
+			cfg = cfg.Clone()
+			cfg.NextProtos = nil
+			transport.TLSClientConfig = cfg
+		}
+	}
+}
+
+// updateTransport 复制默认请求客户端的 transport 修改后替换，关闭旧 transport 的闲置连接
+// 不修改正在使用的 transport，已经开始的下载继续使用旧的 transport，调用时需要持有 rain.mux
+func (rain *Rain) updateTransport(update func(transport *http.Transport)) {
+	old, ok := rain.client.Transport.(*http.Transport)
+	if !ok {
+		return
+	}
+	transport := old.Clone()
+	update(transport)
+	client := *rain.client
+	client.Transport = transport
+	rain.client = &client
+	old.CloseIdleConnections()
+}
+
+// growIdleConns 默认请求客户端的连接池小于协程数量时，替换为更大连接池的 transport
+// 连接池小于协程数量时，多出的连接在下载块结束后会被关闭，无法复用
+// ctl 不为 nil 时使用当前下载的协程数量，并让仍然使用旧 transport 的当前下载改为使用新的 transport
+func (rain *Rain) growIdleConns(ctl *control) {
+	count := rain.config.RoutineCount
+	if ctl != nil {
+		count = ctl.config.RoutineCount
+	}
+	old, ok := rain.client.Transport.(*http.Transport)
+	if !ok || old.MaxIdleConnsPerHost >= count {
+		return
+	}
+	rain.updateTransport(func(transport *http.Transport) {
+		transport.MaxIdleConnsPerHost = count
+	})
+	if ctl != nil && ctl.request.client.Transport == old {
+		client := *ctl.request.client
+		client.Transport = rain.client.Transport
+		ctl.request.client = &client
+	}
+}
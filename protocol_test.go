@@ -0,0 +1,84 @@
+package rain
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestApplyProtocol(t *testing.T) {
+	ctl := &control{
+		config:  NewConfig(),
+		request: &request{client: &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 10}}},
+	}
+	ctl.config.RoutineCount = 16
+	if ctl.needTransport() {
+		t.Fatal("连接池不够时不应该复制 transport")
+	}
+	ctl.config.Protocol = PROTOCOL_HTTP1
+	if !ctl.needTransport() {
+		t.Fatal("协议不同时需要复制 transport")
+	}
+	shared := ctl.request.client.Transport
+	err := ctl.prepareClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := ctl.request.client.Transport.(*http.Transport)
+	if transport == shared || transport.MaxIdleConnsPerHost != 10 {
+		t.Fatal("复制的 transport 错误", transport.MaxIdleConnsPerHost)
+	}
+	if ctl.needTransport() {
+		t.Fatal("协议相同时不需要复制 transport")
+	}
+
+	ctl.config.Protocol = PROTOCOL_AUTO
+	ctl.applyProtocol(transport)
+	if transport.TLSNextProto == nil {
+		t.Fatal("PROTOCOL_AUTO 不应该修改 transport")
+	}
+	ctl.config.Protocol = PROTOCOL_HTTP2
+	ctl.applyProtocol(transport)
+	if transport.TLSNextProto != nil || !transport.ForceAttemptHTTP2 {
+		t.Fatal("PROTOCOL_HTTP2 应该开启 HTTP/2")
+	}
+	ctl.config.Protocol = PROTOCOL_HTTP1
+	ctl.applyProtocol(transport)
+	if transport.TLSNextProto == nil || transport.ForceAttemptHTTP2 {
+		t.Fatal("PROTOCOL_HTTP1 应该关闭 HTTP/2")
+	}
+}
+
+func TestSharedTransport(t *testing.T) {
+	rain := NewRain()
+	old := rain.client.Transport.(*http.Transport)
+	rain.SetRoutineCount(32)
+	transport := rain.client.Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != 32 || old.MaxIdleConnsPerHost != 10 {
+		t.Fatal("没有替换为更大连接池的 transport", transport.MaxIdleConnsPerHost)
+	}
+	rain.SetRoutineCount(4)
+	if rain.client.Transport != transport {
+		t.Fatal("不应该减小共享的连接池")
+	}
+
+	// 当前下载的协程数量更多时也增大共享的连接池
+	ctl := rain.New("http://example.com/a.bin", WithRoutineCount(64), WithCookieJar(NewCookieJar())).ctl
+	transport = rain.client.Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != 64 || ctl.request.client.Transport != transport {
+		t.Fatal("当前下载没有使用更大连接池的 transport", transport.MaxIdleConnsPerHost)
+	}
+
+	// 默认请求客户端已经使用 HTTP/2
+	ctl = rain.New("http://example.com/a.bin", WithProtocol(PROTOCOL_HTTP2)).ctl
+	if ctl.needTransport() {
+		t.Fatal("协议相同时不需要复制 transport")
+	}
+	rain.SetProtocol(PROTOCOL_HTTP1)
+	ctl = rain.New("http://example.com/a.bin").ctl
+	if ctl.needTransport() {
+		t.Fatal("SetProtocol 应该替换共享的 transport")
+	}
+	if !transport.ForceAttemptHTTP2 || rain.client.Transport == http.RoundTripper(transport) {
+		t.Fatal("SetProtocol 不应该修改正在使用的 transport")
+	}
+}
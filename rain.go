@@ -33,6 +33,8 @@ type Rain struct {
 	perm fs.FileMode
 	// outdir 默认输出目录
 	outdir string
+	// hooks 默认的下载生命周期钩子
+	hooks []Hook
 }
 
 // RainControl 下载控制器
@@ -57,10 +59,14 @@ func NewRain() *Rain {
 			Proxy: http.ProxyFromEnvironment,
 			// 要求服务器返回非压缩的内容，前提是没有发送 accept-encoding 来接管 transport 的自动处理
 			DisableCompression: true,
-			// 接受服务器提供的任何证书
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			// 每个服务器最大保留闲置连接数
+			// 验证服务器证书，旧服务器可以通过 SetInsecureSkipVerify 跳过验证
+			TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+			// 每个服务器最大保留闲置连接数，SetRoutineCount 设置更多的协程数量时增大连接池
 			MaxIdleConnsPerHost: 10,
+			// 闲置连接的最长保留时间，避免长期运行时保留已经不再使用的连接
+			IdleConnTimeout: 90 * time.Second,
+			// 自定义 TLSClientConfig 时需要手动开启 HTTP/2
+			ForceAttemptHTTP2: true,
 		},
 		// 超时时间
 		Timeout: 0,
@@ -94,13 +100,18 @@ func (rain *Rain) New(uri string, opts ...OptionFunc) *RainControl {
 			body:   rain.body,
 			header: rain.header.Clone(),
 		},
-		perm:          rain.perm,
-		outdir:        rain.outdir,
-		done:          make(chan error, 1),
-		mux:           sync.Mutex{},
-		completedSize: new(int64),
+		perm:           rain.perm,
+		outdir:         rain.outdir,
+		done:           make(chan error, 1),
+		mux:            sync.Mutex{},
+		completedSize:  new(int64),
+		transferSize:   new(int64),
+		scheduleChange: make(chan struct{}, 1),
 	}
 
+	ctl.request.beforeRequest = ctl.hookBeforeRequest
+	ctl.addHook(rain.hooks...)
+
 	for _, opt := range rain.options {
 		opt(ctl)
 	}
@@ -109,9 +120,24 @@ func (rain *Rain) New(uri string, opts ...OptionFunc) *RainControl {
 		opt(ctl)
 	}
 
+	// 当前下载的协程数量更多时增大共享的连接池
+	rain.growIdleConns(ctl)
+
 	return &RainControl{ctl: ctl}
 }
 
+// Probe 探测资源的大小、类型和文件名等信息，不下载资源，使用与 New 相同的默认参数和 option
+func (rain *Rain) Probe(ctx context.Context, uri string, opts ...OptionFunc) (*ResourceInfo, error) {
+	return rain.New(uri, opts...).ctl.probe(ctx)
+}
+
+// Use 添加默认的下载生命周期钩子，在 WithHook 添加的钩子之前执行
+func (rain *Rain) Use(h ...Hook) {
+	rain.mux.Lock()
+	defer rain.mux.Unlock()
+	rain.hooks = append(rain.hooks, h...)
+}
+
 // AddOptions 添加 New 时的 option
 func (rain *Rain) AddOptions(opt ...OptionFunc) {
 	rain.mux.Lock()
@@ -126,6 +152,71 @@ func (rain *Rain) SetOptions(opt []OptionFunc) {
 	rain.options = opt
 }
 
+// SetMaxRedirects 设置最多跳转次数
+func (rain *Rain) SetMaxRedirects(d int) {
+	rain.config.MaxRedirects = d
+}
+
+// SetProtocol 设置下载使用的 HTTP 协议，默认请求客户端的 Transport 为 *http.Transport 时替换为设置了协议的 transport，下载不需要复制 transport
+func (rain *Rain) SetProtocol(d Protocol) {
+	rain.mux.Lock()
+	defer rain.mux.Unlock()
+
+	rain.config.Protocol = d
+	if d != PROTOCOL_AUTO {
+		rain.updateTransport(func(transport *http.Transport) {
+			setProtocol(transport, d)
+		})
+	}
+}
+
+// SetProxyURL 设置客户端代理，支持 http、https、socks5 和 socks5h，认证信息写在链接中，多个代理时每个请求轮流使用
+func (rain *Rain) SetProxyURL(proxies ...string) error {
+	list, err := parseProxies(proxies)
+	if err != nil {
+		return err
+	}
+	return rain.SetProxy(ProxyRotation(list...))
+}
+
+// SetTLSConfig 设置默认请求客户端的 TLS 配置，默认请求客户端的 Transport 为 *http.Transport 时有效
+// 使用 cfg 的副本，之后修改 cfg 不会影响请求客户端
+func (rain *Rain) SetTLSConfig(cfg *tls.Config) {
+	if transport, ok := rain.client.Transport.(*http.Transport); ok {
+		transport.TLSClientConfig = cfg.Clone()
+	}
+}
+
+// SetCACertFile 默认请求客户端在系统证书的基础上添加 PEM 格式的 CA 证书
+func (rain *Rain) SetCACertFile(path string) error {
+	return rain.setTLS(&tlsSettings{caFiles: []string{path}})
+}
+
+// SetClientCert 默认请求客户端使用 PEM 格式的客户端证书和私钥进行双向认证
+func (rain *Rain) SetClientCert(certFile, keyFile string) error {
+	return rain.setTLS(&tlsSettings{certFile: certFile, keyFile: keyFile})
+}
+
+// SetTLSMinVersion 设置默认请求客户端的最低 TLS 版本
+func (rain *Rain) SetTLSMinVersion(v uint16) error {
+	return rain.setTLS(&tlsSettings{minVersion: v})
+}
+
+// SetPinnedSPKI 默认请求客户端固定服务器证书的公钥，值为证书 SubjectPublicKeyInfo 的 sha256 base64
+func (rain *Rain) SetPinnedSPKI(pins ...string) error {
+	return rain.setTLS(&tlsSettings{pins: pins})
+}
+
+// SetInsecureSkipVerify 默认请求客户端跳过服务器证书验证，只用于无法验证证书的旧服务器
+func (rain *Rain) SetInsecureSkipVerify(d bool) error {
+	return rain.setTLS(&tlsSettings{insecure: &d})
+}
+
+// SetCookieJar 设置默认请求客户端的 cookie jar
+func (rain *Rain) SetCookieJar(jar http.CookieJar) {
+	rain.client.Jar = jar
+}
+
 // SetClient 设置默认请求客户端
 func (rain *Rain) SetClient(d *http.Client) {
 	rain.client = d
@@ -190,9 +281,18 @@ func (rain *Rain) SetRoutineSize(d int64) {
 	rain.config.RoutineSize = d
 }
 
-// SetRoutineCount 设置协程最大数
+// SetRoutineCount 设置协程最大数，默认请求客户端的连接池小于协程数量时增大连接池
 func (rain *Rain) SetRoutineCount(d int) {
+	rain.mux.Lock()
+	defer rain.mux.Unlock()
+
 	rain.config.RoutineCount = d
+	rain.growIdleConns(nil)
+}
+
+// SetRoutineAuto 设置是否根据下载速度自动调整协程数量，协程最大数为上限
+func (rain *Rain) SetRoutineAuto(d bool) {
+	rain.config.RoutineAuto = d
 }
 
 // SetDiskCache 设置磁盘缓冲区大小
@@ -205,6 +305,27 @@ func (rain *Rain) SetSpeedLimit(d int) {
 	rain.config.SpeedLimit = d
 }
 
+// SetSpeedBurst 设置限速器允许的突发字节数
+func (rain *Rain) SetSpeedBurst(d int) {
+	rain.config.SpeedBurst = d
+}
+
+// SetSpeedSchedule 设置分时段限速计划
+func (rain *Rain) SetSpeedSchedule(d *SpeedSchedule) {
+	rain.config.SpeedSchedule = d
+}
+
+// SetLowSpeedLimit 设置低速检测，下载块在 window 内速度持续低于 bytesPerSec 时重试，重试后仍然低速返回 ErrStalled
+func (rain *Rain) SetLowSpeedLimit(bytesPerSec int, window time.Duration) {
+	rain.config.LowSpeedLimit = bytesPerSec
+	rain.config.LowSpeedTime = window
+}
+
+// SetProbeHead 设置探测资源时是否先尝试 HEAD 请求
+func (rain *Rain) SetProbeHead(d bool) {
+	rain.config.ProbeHead = d
+}
+
 // SetCreateDir 设置是否可以创建目录
 func (rain *Rain) SetCreateDir(d bool) {
 	rain.config.CreateDir = d
@@ -225,16 +346,51 @@ func (rain *Rain) SetAutoFileRenaming(d bool) {
 	rain.config.AutoFileRenaming = d
 }
 
+// SetRenameStrategy 设置自动重命名的规则
+func (rain *Rain) SetRenameStrategy(d RenameStrategy) {
+	rain.config.RenameStrategy = d
+}
+
+// SetRenameMaxAttempts 设置自动重命名的最多次数，超过后返回 *RenameError
+func (rain *Rain) SetRenameMaxAttempts(d int) {
+	rain.config.RenameMaxAttempts = d
+}
+
 // SetAutoFilterFilename 设置是否自动过滤掉文件名称中的非法字符
 func (rain *Rain) SetAutoFilterFilename(d bool) {
 	rain.config.AutoFilterFilename = d
 }
 
-// SetTimeout 设置下载超时时间
+// SetFilenamePolicy 设置过滤文件名称时使用的规则
+func (rain *Rain) SetFilenamePolicy(d FilenamePolicy) {
+	rain.config.FilenamePolicy = d
+}
+
+// SetFilenameReplacement 设置替换文件名称中非法字符的字符串，为空时直接删除非法字符
+func (rain *Rain) SetFilenameReplacement(d string) {
+	rain.config.FilenameReplacement = d
+}
+
+// SetTimeout 设置下载总超时时间，暂停的时间不计入
 func (rain *Rain) SetTimeout(d time.Duration) {
 	rain.config.Timeout = d
 }
 
+// SetConnectTimeout 设置建立连接的超时时间
+func (rain *Rain) SetConnectTimeout(d time.Duration) {
+	rain.config.ConnectTimeout = d
+}
+
+// SetResponseHeaderTimeout 设置建立连接后等待响应头的超时时间
+func (rain *Rain) SetResponseHeaderTimeout(d time.Duration) {
+	rain.config.ResponseHeaderTimeout = d
+}
+
+// SetIdleTimeout 设置读取数据时的空闲超时时间
+func (rain *Rain) SetIdleTimeout(d time.Duration) {
+	rain.config.IdleTimeout = d
+}
+
 // SetRetryNumber 设置请求重试次数
 func (rain *Rain) SetRetryNumber(d int) {
 	rain.config.RetryNumber = d
@@ -250,6 +406,26 @@ func (rain *Rain) SetBreakpointExt(d string) {
 	rain.config.BreakpointExt = d
 }
 
+// SetDecompress 请求压缩传输并将内容解码后写入文件，只使用单协程下载
+func (rain *Rain) SetDecompress(d bool) {
+	rain.config.Decompress = d
+}
+
+// SetSyncMode 设置同步模式，输出文件已经存在时只在资源发生变化时重新下载并覆盖
+func (rain *Rain) SetSyncMode(d bool) {
+	rain.config.SyncMode = d
+}
+
+// SetMetaExt 同步模式下保存资源信息的文件扩展, 默认为 .meta.rain
+func (rain *Rain) SetMetaExt(d string) {
+	rain.config.MetaExt = d
+}
+
+// SetOutTemplate 输出路径模板，没有设置输出文件名时根据资源信息计算相对于输出目录的路径
+func (rain *Rain) SetOutTemplate(tmpl string) {
+	rain.config.OutTemplate = tmpl
+}
+
 // Run 阻塞运行下载
 func (rc *RainControl) Run() (*RainControl, error) {
 	return rc.RunContext(context.Background())
@@ -300,6 +476,19 @@ func (rc *RainControl) Outpath() string {
 	return rc.ctl.outpath
 }
 
+// ResourceInfo 获取资源探测信息，下载开始之前为 nil
+func (rc *RainControl) ResourceInfo() *ResourceInfo {
+	return rc.ctl.resourceInfo
+}
+
+// FinalURI 跳转后的链接，下载开始之前为空
+func (rc *RainControl) FinalURI() string {
+	if rc.ctl.resourceInfo == nil {
+		return ""
+	}
+	return rc.ctl.resourceInfo.FinalURI
+}
+
 // Status 获取下载状态
 func (rc *RainControl) Status() Status {
 	return rc.ctl.status
@@ -309,3 +498,9 @@ func (rc *RainControl) Status() Status {
 func (rc *RainControl) SetSpeedLimit(d int) {
 	rc.ctl.setSpeedLimit(d)
 }
+
+// SetSpeedSchedule 设置分时段限速计划，nil 为取消计划
+// 计划生效期间手动设置的限速会在下一次时段切换时被覆盖
+func (rc *RainControl) SetSpeedSchedule(d *SpeedSchedule) {
+	rc.ctl.setSpeedSchedule(d)
+}
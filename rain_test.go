@@ -1,12 +1,26 @@
 package rain_test
 
 import (
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/md5"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -16,6 +30,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -32,53 +48,73 @@ func init() {
 // NewFileServer 新建测试文件服务
 func NewFileServer(t *testing.T, path string, exec ...func(w http.ResponseWriter, r *http.Request)) *httptest.Server {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		Execute := func(w http.ResponseWriter, r *http.Request) {
-			if len(exec) > 0 {
-				exec[0](w, r)
-			}
-		}
-
 		data, err := os.ReadFile(path)
 		if err != nil {
 			t.Fatal(err)
 		}
+		ServeData(t, w, r, data, exec...)
+	}))
+	_, filename := filepath.Split(path)
+	server.URL = server.URL + "/test/" + filename
+	return server
+}
 
-		w.Header().Set("etag", MD5(data))
-		w.Header().Set("content-length", fmt.Sprint(len(data)))
-		w.Header().Set("accept-ranges", "bytes")
+// NewDataServer 新建内存数据测试服务
+func NewDataServer(t *testing.T, name string, data []byte, exec ...func(w http.ResponseWriter, r *http.Request)) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeData(t, w, r, data, exec...)
+	}))
+	server.URL = server.URL + "/test/" + name
+	return server
+}
 
-		hrange := r.Header.Get("range")
-		if hrange != "" {
-			ranges := regexp.MustCompile(`bytes=(\d+)-(\d+)`).FindStringSubmatch(hrange)
-			if len(ranges) != 3 {
-				t.Fatal("bytes 长度错误")
-			}
-			start, err := strconv.ParseInt(ranges[1], 10, 64)
-			if err != nil {
-				t.Fatal(err)
-			}
-			end, err := strconv.ParseInt(ranges[2], 10, 64)
-			if err != nil {
-				t.Fatal(err)
-			}
-			w.Header().Set("content-range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
-			w.Header().Set("content-length", fmt.Sprint(end-start+1))
+// ServeData 响应测试数据，支持 range 请求
+func ServeData(t *testing.T, w http.ResponseWriter, r *http.Request, data []byte, exec ...func(w http.ResponseWriter, r *http.Request)) {
+	Execute := func(w http.ResponseWriter, r *http.Request) {
+		if len(exec) > 0 {
+			exec[0](w, r)
+		}
+	}
 
-			Execute(w, r)
+	w.Header().Set("etag", MD5(data))
+	w.Header().Set("content-length", fmt.Sprint(len(data)))
+	w.Header().Set("accept-ranges", "bytes")
 
-			w.WriteHeader(http.StatusPartialContent)
-			io.Copy(w, bytes.NewBuffer(data[start:end+1]))
-			return
+	hrange := r.Header.Get("range")
+	if hrange != "" {
+		ranges := regexp.MustCompile(`bytes=(\d+)-(\d+)`).FindStringSubmatch(hrange)
+		if len(ranges) != 3 {
+			t.Fatal("bytes 长度错误")
+		}
+		start, err := strconv.ParseInt(ranges[1], 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		end, err := strconv.ParseInt(ranges[2], 10, 64)
+		if err != nil {
+			t.Fatal(err)
 		}
+		w.Header().Set("content-range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		w.Header().Set("content-length", fmt.Sprint(end-start+1))
 
 		Execute(w, r)
 
-		w.WriteHeader(http.StatusOK)
-		io.Copy(w, bytes.NewBuffer(data))
-	}))
-	_, filename := filepath.Split(path)
-	server.URL = server.URL + "/test/" + filename
-	return server
+		w.WriteHeader(http.StatusPartialContent)
+		io.Copy(w, bytes.NewBuffer(data[start:end+1]))
+		return
+	}
+
+	Execute(w, r)
+
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, bytes.NewBuffer(data))
+}
+
+// RandomData 生成随机测试数据
+func RandomData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(time.Now().UnixNano())).Read(data)
+	return data
 }
 
 func MD5(data []byte) string {
@@ -545,3 +581,1785 @@ func TestOutfile(t *testing.T) {
 		}
 	}
 }
+
+// TestSpeedLimitUpdate 测试运行中修改限速和限速中取消下载
+func TestSpeedLimitUpdate(t *testing.T) {
+	Init()
+	data := RandomData(4 << 20)
+	server := NewDataServer(t, "speed.bin", data)
+	defer server.Close()
+
+	ctl, err := rain.New(server.URL, rain.WithSpeedLimit(rain.COPY_BUFFER_SIZE)).Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 限速中取消下载需要及时返回
+	time.Sleep(time.Millisecond * 500)
+	closeStart := time.Now()
+	ctl.Close()
+	if time.Since(closeStart) > time.Second {
+		t.Fatal("限速中取消下载没有及时返回")
+	}
+
+	// 运行中取消限速
+	_, err = ctl.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 500)
+	ctl.SetSpeedLimit(0)
+	select {
+	case err = <-ctl.WaitChan():
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("取消限速没有生效")
+	}
+	if FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("md5 错误")
+	}
+}
+
+// TestLowSpeedLimit 测试低速检测
+func TestLowSpeedLimit(t *testing.T) {
+	Init()
+	data := RandomData(2 << 20)
+	var stallCount int32
+	// 第一个下载块的第一次请求只返回部分数据后挂起
+	server := NewDataServer(t, "stall.bin", data, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("range"), "bytes=0-") || r.Header.Get("range") == "bytes=0-261" {
+			return
+		}
+		if atomic.AddInt32(&stallCount, 1) > 1 {
+			return
+		}
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[:1024])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		panic(http.ErrAbortHandler)
+	})
+	defer server.Close()
+
+	ctl, err := rain.New(
+		server.URL,
+		rain.WithRoutineCount(2),
+		rain.WithRoutineSize(1<<20),
+		rain.WithLowSpeedLimit(1024, time.Millisecond*500),
+	).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("md5 错误")
+	}
+
+	// 等待响应头的时间不计入低速检测
+	slow := NewDataServer(t, "slow.bin", data, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("range") != "bytes=0-261" {
+			time.Sleep(time.Millisecond * 600)
+		}
+	})
+	defer slow.Close()
+
+	ctl, err = rain.New(
+		slow.URL,
+		rain.WithRoutineCount(2),
+		rain.WithRoutineSize(1<<20),
+		rain.WithRetryNumber(1),
+		rain.WithLowSpeedLimit(1024, time.Millisecond*200),
+	).Run()
+	if err != nil {
+		t.Fatal("等待响应头的时间不应该计入低速检测", err)
+	}
+	if FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("md5 错误")
+	}
+
+	// 收到响应头后一直不发送数据时返回 ErrStalled
+	hang := NewDataServer(t, "hang.bin", data, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("range") == "bytes=0-261" {
+			return
+		}
+		w.WriteHeader(http.StatusPartialContent)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		panic(http.ErrAbortHandler)
+	})
+	defer hang.Close()
+
+	_, err = rain.New(
+		hang.URL,
+		rain.WithRoutineCount(2),
+		rain.WithRoutineSize(1<<20),
+		rain.WithRetryNumber(1),
+		rain.WithLowSpeedLimit(1024, time.Millisecond*200),
+	).Run()
+	if !errors.Is(err, rain.ErrStalled) {
+		t.Fatal("应该返回 ErrStalled", err)
+	}
+}
+
+// TestTimeouts 测试分阶段的超时时间
+func TestTimeouts(t *testing.T) {
+	Init()
+	data := RandomData(4 << 20)
+
+	// 等待响应头超时
+	slow := NewDataServer(t, "slow.bin", data, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	})
+	defer slow.Close()
+	start := time.Now()
+	_, err := rain.New(slow.URL, rain.WithResponseHeaderTimeout(time.Millisecond*200), rain.WithRetryNumber(1)).Run()
+	if !errors.Is(err, rain.ErrResponseHeaderTimeout) {
+		t.Fatal("应该返回 ErrResponseHeaderTimeout", err)
+	}
+	if time.Since(start) > time.Millisecond*800 {
+		t.Fatal("等待响应头超时没有及时返回")
+	}
+
+	// 读取空闲超时后重试下载块
+	var idleCount int32
+	idle := NewDataServer(t, "idle.bin", data, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("range") == "bytes=0-261" || atomic.AddInt32(&idleCount, 1) > 1 {
+			return
+		}
+		w.WriteHeader(http.StatusPartialContent)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		panic(http.ErrAbortHandler)
+	})
+	defer idle.Close()
+	ctl, err := rain.New(
+		idle.URL,
+		rain.WithRoutineCount(2),
+		rain.WithRoutineSize(1<<20),
+		rain.WithIdleTimeout(time.Millisecond*300),
+	).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("md5 错误")
+	}
+
+	// 暂停的时间不计入总超时时间，继续下载时只剩余未使用的时间
+	server := NewDataServer(t, "total.bin", data)
+	defer server.Close()
+	ctl, err = rain.New(server.URL, rain.WithSpeedLimit(1<<20), rain.WithTimeout(time.Millisecond*1500)).Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	ctl.Close()
+	time.Sleep(time.Second)
+	_, err = ctl.Run()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("应该返回超时错误", err)
+	}
+}
+
+// TestRoutineAuto 测试自动调整协程数量
+func TestRoutineAuto(t *testing.T) {
+	Init()
+	data := RandomData(8 << 20)
+	server := NewDataServer(t, "auto.bin", data)
+	defer server.Close()
+	ctl, err := rain.New(
+		server.URL,
+		rain.WithRoutineAuto(true),
+		rain.WithRoutineCount(4),
+		rain.WithRoutineSize(256<<10),
+		rain.WithSpeedLimit(2<<20),
+	).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("md5 错误")
+	}
+}
+
+// TestEmptyFile 测试下载空文件
+func TestEmptyFile(t *testing.T) {
+	Init()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-range", "bytes */0")
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+	}))
+	defer server.Close()
+	ctl, err := rain.New(server.URL+"/empty.txt", rain.WithProbeHead(true)).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat(ctl.Outpath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != 0 || ctl.ResourceInfo().Filesize != 0 {
+		t.Fatal("空文件大小错误")
+	}
+}
+
+// TestProbe 测试探测资源信息
+func TestProbe(t *testing.T) {
+	data := RandomData(1 << 20)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/test/probe.bin", http.StatusFound)
+	})
+	mux.HandleFunc("/test/probe.bin", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("test") != "header" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("content-type", "application/octet-stream")
+		w.Header().Set("last-modified", "Sat, 01 Oct 2022 00:00:00 GMT")
+		w.Header().Set("content-disposition", `attachment; filename="probe:data.bin"`)
+		ServeData(t, w, r, data)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	info, err := rain.Probe(context.Background(), server.URL+"/redirect", rain.WithHeader(func(h http.Header) {
+		h.Set("test", "header")
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Filesize != int64(len(data)) || !info.Multithread {
+		t.Fatal("资源大小错误", info.Filesize)
+	}
+	if info.Filename != "probedata.bin" {
+		t.Fatal("文件名错误", info.Filename)
+	}
+	if info.FinalURI != server.URL+"/test/probe.bin" {
+		t.Fatal("最终链接错误", info.FinalURI)
+	}
+	if info.Etag != MD5(data) || info.LastModified != "Sat, 01 Oct 2022 00:00:00 GMT" || info.ContentType != "application/octet-stream" {
+		t.Fatalf("资源信息错误 %#v", info)
+	}
+}
+
+// RestartEvent 重新下载事件
+type RestartEvent struct {
+	EventExtend
+	RestartCount int32
+}
+
+func (re *RestartEvent) Restart(stat *rain.EventExtend) {
+	atomic.AddInt32(&re.RestartCount, 1)
+}
+
+var _ rain.ProgressEventExtendRestart = &RestartEvent{}
+
+// TestResourceChanged 测试断点续传时资源发生变化
+func TestResourceChanged(t *testing.T) {
+	Init()
+	var (
+		mux     sync.Mutex
+		data    = RandomData(4 << 20)
+		modtime = time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		d, m := data, modtime
+		mux.Unlock()
+		// 没有 etag，只能通过 Last-Modified 判断资源是否变化
+		http.ServeContent(w, r, "", m, bytes.NewReader(d))
+	}))
+	defer server.Close()
+
+	event := &RestartEvent{}
+	ctl, err := rain.New(
+		server.URL+"/changed.bin",
+		rain.WithRoutineCount(2),
+		rain.WithRoutineSize(1<<20),
+		rain.WithSpeedLimit(1<<20),
+		rain.WithEventExtend(event),
+	).Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 1500)
+	ctl.Close()
+
+	// 相同大小的新内容
+	mux.Lock()
+	data = RandomData(4 << 20)
+	modtime = modtime.Add(time.Hour)
+	mux.Unlock()
+
+	ctl.SetSpeedLimit(0)
+	_, err = ctl.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("md5 错误")
+	}
+	if atomic.LoadInt32(&event.RestartCount) != 1 {
+		t.Fatal("重新下载事件数量错误", event.RestartCount)
+	}
+}
+
+// TestSyncMode 测试同步模式，资源没有变化时不重新下载
+func TestSyncMode(t *testing.T) {
+	Init()
+	var (
+		mux      sync.Mutex
+		data     = RandomData(1 << 20)
+		etag     = `"v1"`
+		modified int32
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		d, e := data, etag
+		mux.Unlock()
+		if r.Header.Get("if-none-match") == e {
+			atomic.AddInt32(&modified, 1)
+		}
+		w.Header().Set("etag", e)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(d))
+	}))
+	defer server.Close()
+
+	run := func() *rain.RainControl {
+		ctl, err := rain.New(
+			server.URL+"/sync.bin",
+			rain.WithOutdir("./tmp"),
+			rain.WithOutname("sync.bin"),
+			rain.WithSyncMode(true),
+		).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ctl
+	}
+
+	ctl := run()
+	if ctl.Status() != rain.STATUS_FINISH || FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("首次下载错误")
+	}
+
+	// 资源没有变化
+	ctl = run()
+	if ctl.Status() != rain.STATUS_UNCHANGED {
+		t.Fatal("资源没有变化时状态错误", ctl.Status())
+	}
+	if atomic.LoadInt32(&modified) != 1 {
+		t.Fatal("没有携带条件请求头")
+	}
+
+	// 资源变化后重新下载并覆盖
+	mux.Lock()
+	data = RandomData(1 << 20)
+	etag = `"v2"`
+	mux.Unlock()
+	ctl = run()
+	if ctl.Status() != rain.STATUS_FINISH || FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("资源变化后下载错误")
+	}
+	if filepath.Base(ctl.Outpath()) != "sync.bin" {
+		t.Fatal("资源变化后没有覆盖文件", ctl.Outpath())
+	}
+}
+
+// ChunkedServer 不返回资源大小的分块传输服务，rangeable 为 true 时支持 range 请求
+func ChunkedServer(t *testing.T, data []byte, rangeable bool, resumed *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start, end := int64(0), int64(len(data)-1)
+		if rg := r.Header.Get("range"); rg != "" && rangeable {
+			if n, _ := fmt.Sscanf(rg, "bytes=%d-%d", &start, &end); n == 1 && start > 0 {
+				atomic.StoreInt64(resumed, start)
+			}
+			w.Header().Set("content-range", fmt.Sprintf("bytes %d-%d/*", start, end))
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		body := data[start : end+1]
+		for len(body) > 0 {
+			n := 32 << 10
+			if n > len(body) {
+				n = len(body)
+			}
+			if _, err := w.Write(body[:n]); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			body = body[n:]
+		}
+	}))
+}
+
+// TotalEvent 记录最后一次事件中的资源大小
+type TotalEvent struct {
+	TotalLength     int64
+	CompletedLength int64
+	TransferLength  int64
+	Proto           string
+}
+
+func (te *TotalEvent) Change(stat *rain.Stat) {
+	te.Proto = stat.Proto
+	te.TotalLength = stat.TotalLength
+	te.CompletedLength = stat.CompletedLength
+	te.TransferLength = stat.TransferLength
+}
+
+// TestResumeUnknownSize 测试未知大小和不支持 range 的单协程断点续传
+func TestResumeUnknownSize(t *testing.T) {
+	for _, rangeable := range []bool{true, false} {
+		Init()
+		var (
+			data    = RandomData(8 << 20)
+			resumed int64
+		)
+		server := ChunkedServer(t, data, rangeable, &resumed)
+
+		ctl, err := rain.New(
+			server.URL+"/chunked.bin",
+			rain.WithSpeedLimit(1<<20),
+		).Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond * 1200)
+		ctl.Close()
+		if ctl.ResourceInfo().Filesize != -1 || ctl.ResourceInfo().Multithread {
+			t.Fatal("资源大小应该未知", ctl.ResourceInfo())
+		}
+
+		event := &TotalEvent{}
+		_, err = rain.New(server.URL+"/chunked.bin", rain.WithEvent(event)).Run()
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if FileMD5(ctl.Outpath()) != MD5(data) {
+			t.Fatal("md5 错误", rangeable)
+		}
+		if event.TotalLength != int64(len(data)) {
+			t.Fatal("完成时资源大小错误", event.TotalLength)
+		}
+		if rangeable && atomic.LoadInt64(&resumed) == 0 {
+			t.Fatal("没有从已下载的位置继续")
+		}
+	}
+}
+
+// TestDecompress 测试压缩传输的解码
+func TestDecompress(t *testing.T) {
+	Init()
+	data := bytes.Repeat([]byte(`{"id":1,"name":"rain"},`), 1<<16)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(data)
+	zw.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch encoding := r.Header.Get("accept-encoding"); {
+		case r.URL.Path == "/unknown.json" && encoding != "":
+			w.Header().Set("content-encoding", "br")
+			w.Write(data)
+		case strings.Contains(encoding, "x-plain"):
+			w.Header().Set("content-encoding", "x-plain")
+			w.Write(data)
+		case strings.Contains(encoding, "gzip"):
+			w.Header().Set("content-encoding", "gzip")
+			w.Write(gz.Bytes())
+		default:
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		}
+	}))
+	defer server.Close()
+
+	event := &TotalEvent{}
+	ctl, err := rain.New(
+		server.URL+"/export.json",
+		rain.WithDecompress(true),
+		rain.WithEvent(event),
+	).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("md5 错误")
+	}
+	if event.CompletedLength != int64(len(data)) || event.TransferLength != int64(gz.Len()) {
+		t.Fatal("解码前后大小错误", event.CompletedLength, event.TransferLength)
+	}
+
+	// 没有注册解码器的编码
+	_, err = rain.New(server.URL+"/unknown.json", rain.WithDecompress(true)).Run()
+	if !errors.Is(err, rain.ErrUnsupportedEncoding) {
+		t.Fatal("没有返回不支持的编码错误", err)
+	}
+
+	// 注册的解码器
+	rain.RegisterDecoder("x-plain", func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(r), nil
+	})
+	ctl, err = rain.New(server.URL+"/plain.json", rain.WithDecompress(true)).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("注册的解码器 md5 错误")
+	}
+}
+
+// TestPostProcess 测试下载完成后解压压缩包
+func TestPostProcess(t *testing.T) {
+	Init()
+	files := map[string][]byte{
+		"release/app.bin":    RandomData(256 << 10),
+		"release/readme.txt": []byte("rain"),
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	zw.Close()
+	server := NewDataServer(t, "release.zip", buf.Bytes())
+	defer server.Close()
+
+	var steps []string
+	ctl, err := rain.New(
+		server.URL+"/release.zip",
+		rain.WithPostProcessor(
+			&rain.Extractor{Dir: "./tmp/release", RemoveArchive: true},
+			rain.PostProcessorFunc(func(ctx context.Context, p *rain.PostProcess) error {
+				steps = append(steps, filepath.Base(p.Outpath))
+				return nil
+			}),
+		),
+	).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctl.Status() != rain.STATUS_FINISH {
+		t.Fatal("状态错误", ctl.Status())
+	}
+	for name, data := range files {
+		if FileMD5(filepath.Join("./tmp/release", name)) != MD5(data) {
+			t.Fatal("解压文件错误", name)
+		}
+	}
+	if _, err := os.Stat(ctl.Outpath()); !os.IsNotExist(err) {
+		t.Fatal("没有删除压缩包")
+	}
+	if len(steps) != 1 || steps[0] != "release.zip" {
+		t.Fatal("处理步骤没有按顺序执行", steps)
+	}
+
+	// 处理步骤失败时返回错误
+	_, err = rain.New(
+		server.URL+"/release.zip",
+		rain.WithAllowOverwrite(true),
+		rain.WithPostProcessor(rain.PostProcessorFunc(func(ctx context.Context, p *rain.PostProcess) error {
+			return errors.New("post process failed")
+		})),
+	).Run()
+	if err == nil || err.Error() != "post process failed" {
+		t.Fatal("没有返回处理步骤的错误", err)
+	}
+}
+
+// TestHook 测试下载生命周期钩子
+func TestHook(t *testing.T) {
+	Init()
+	var (
+		data    = RandomData(1 << 20)
+		signed  int32
+		unknown int32
+	)
+	server := NewDataServer(t, "hook.bin", data, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/hook.bin") || r.Header.Get("x-shared") != "" {
+			atomic.AddInt32(&unknown, 1)
+		}
+		if r.Header.Get("x-block") == "1" {
+			atomic.AddInt32(&signed, 1)
+		}
+	})
+	defer server.Close()
+
+	var order []string
+	r := rain.NewRain()
+	r.SetOutdir("./tmp")
+	r.Use(&rain.HookFuncs{
+		BeforeProbeFunc: func(h *rain.HookContext) error {
+			order = append(order, "use")
+			h.URI = strings.Replace(h.URI, "/mirror.bin", "/hook.bin", 1)
+			return nil
+		},
+	})
+	ctl, err := r.New(
+		strings.Replace(server.URL, "/hook.bin", "/mirror.bin", 1),
+		rain.WithRoutineCount(2),
+		rain.WithRoutineSize(256<<10),
+		rain.WithHook(&rain.HookFuncs{
+			BeforeProbeFunc: func(h *rain.HookContext) error {
+				order = append(order, "with")
+				return nil
+			},
+			AfterProbeFunc: func(h *rain.HookContext) error {
+				if h.Outname != "hook.bin" || h.ResourceInfo.Filesize != int64(len(data)) {
+					t.Error("探测信息错误", h.Outname)
+				}
+				h.Outname = "renamed.bin"
+				return nil
+			},
+			BeforeRequestFunc: func(h *rain.HookContext, req *http.Request) error {
+				// 修改 HookContext 的请求头不影响其他下载块的请求
+				h.Header.Set("x-shared", req.Header.Get("range"))
+				req.Header.Set("x-block", "1")
+				return nil
+			},
+			AfterFinishFunc: func(h *rain.HookContext, err error) error {
+				if err != nil {
+					return err
+				}
+				target := h.Outpath + ".done"
+				h.Outpath = target
+				return os.Rename(strings.TrimSuffix(target, ".done"), target)
+			},
+		}),
+	).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(order, ",") != "use,with" {
+		t.Fatal("钩子执行顺序错误", order)
+	}
+	if filepath.Base(ctl.Outpath()) != "renamed.bin.done" || FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("输出文件错误", ctl.Outpath())
+	}
+	if atomic.LoadInt32(&signed) != 4 || atomic.LoadInt32(&unknown) != 0 {
+		t.Fatal("请求钩子错误", signed, unknown)
+	}
+
+	// 取消下载
+	veto := errors.New("veto")
+	ctl, err = r.New(server.URL, rain.WithHook(&rain.HookFuncs{
+		AfterProbeFunc: func(h *rain.HookContext) error {
+			return veto
+		},
+	})).Run()
+	if !errors.Is(err, veto) {
+		t.Fatal("没有取消下载", err)
+	}
+	if ctl.Outpath() != "" {
+		t.Fatal("取消下载后不应该创建文件")
+	}
+}
+
+// TestRequestSigner 测试请求签名和过期链接的刷新
+func TestRequestSigner(t *testing.T) {
+	Init()
+	var (
+		data            = RandomData(2 << 20)
+		gen       int32 = 1
+		refreshes int32
+		forbidden int32
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sig") != fmt.Sprint(atomic.LoadInt32(&gen)) || r.Header.Get("x-sign") != "ok" {
+			atomic.AddInt32(&forbidden, 1)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		ServeData(t, w, r, data)
+	}))
+	defer server.Close()
+
+	// 第一个下载块完成后链接过期
+	ctl, err := rain.New(
+		server.URL+"/signed.bin?sig=1",
+		rain.WithRoutineCount(2),
+		rain.WithRoutineSize(512<<10),
+		rain.WithHook(&rain.HookFuncs{
+			BeforeRequestFunc: func(h *rain.HookContext, req *http.Request) error {
+				if !strings.HasPrefix(req.Header.Get("range"), "bytes=0-") {
+					atomic.CompareAndSwapInt32(&gen, 1, 2)
+				}
+				return nil
+			},
+		}),
+		rain.WithRequestSigner(
+			rain.RefreshURL(func(ctx context.Context) (string, error) {
+				atomic.AddInt32(&refreshes, 1)
+				return fmt.Sprintf("%s/signed.bin?sig=%d", server.URL, atomic.LoadInt32(&gen)), nil
+			}),
+			rain.RequestSignerFunc(func(req *http.Request, refresh bool) error {
+				req.Header.Set("x-sign", "ok")
+				return nil
+			}),
+		),
+	).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("md5 错误")
+	}
+	if atomic.LoadInt32(&refreshes) != 1 || atomic.LoadInt32(&forbidden) < 1 {
+		t.Fatal("刷新链接次数错误", refreshes, forbidden)
+	}
+}
+
+// TestAuth 测试 Basic、Bearer 和 .netrc 认证
+func TestAuth(t *testing.T) {
+	Init()
+	var (
+		data   = RandomData(256 << 10)
+		tokens int32
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/bearer.bin") && r.Header.Get("authorization") == "Bearer token-2":
+		case strings.HasSuffix(r.URL.Path, "/basic.bin") && ok && user == "alice" && pass == "secret":
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		ServeData(t, w, r, data)
+	}))
+	defer server.Close()
+
+	netrc := filepath.Join(t.TempDir(), ".netrc")
+	u, _ := url.Parse(server.URL)
+	os.WriteFile(netrc, []byte("machine "+u.Hostname()+" login alice password secret\n"), 0600)
+
+	opts := map[string]rain.OptionFunc{
+		"basic.bin": rain.WithBasicAuth("alice", "secret"),
+		"bearer.bin": rain.WithBearerTokenSource(func(ctx context.Context) (string, error) {
+			// 第一个 token 已经过期
+			return fmt.Sprintf("token-%d", atomic.AddInt32(&tokens, 1)), nil
+		}),
+	}
+	for name, opt := range opts {
+		ctl, err := rain.New(server.URL+"/"+name, opt, rain.WithRetryNumber(1)).Run()
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if FileMD5(ctl.Outpath()) != MD5(data) {
+			t.Fatal(name, "md5 错误")
+		}
+	}
+	if atomic.LoadInt32(&tokens) != 2 {
+		t.Fatal("token 刷新次数错误", tokens)
+	}
+
+	ctl, err := rain.New(server.URL+"/basic.bin", rain.WithNetrc(netrc), rain.WithOutname("netrc.bin")).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("netrc md5 错误")
+	}
+}
+
+// TestCookieFile 测试 cookie 文件的加载和保存
+func TestCookieFile(t *testing.T) {
+	Init()
+	data := RandomData(256 << 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := r.Cookie("session")
+		if err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case r.URL.Path == "/start" && session.Value == "old":
+			// 跳转时更新会话 cookie
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "new", Path: "/"})
+			fallthrough
+		case r.URL.Path == "/start":
+			http.Redirect(w, r, "/file.bin", http.StatusFound)
+		case r.URL.Path == "/file.bin" && session.Value == "new":
+			ServeData(t, w, r, data)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	path := filepath.Join(t.TempDir(), "cookies.txt")
+	os.WriteFile(path, []byte(u.Hostname()+"\tFALSE\t/\tFALSE\t0\tsession\told\n"), 0600)
+
+	ctl, err := rain.New(server.URL+"/start", rain.WithCookieFile(path, true), rain.WithOutname("cookie.bin")).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("md5 错误")
+	}
+	d, _ := os.ReadFile(path)
+	if !strings.Contains(string(d), "\tsession\tnew") {
+		t.Fatal("没有保存更新的 cookie", string(d))
+	}
+
+	// 不影响其他下载
+	_, err = rain.New(server.URL+"/start", rain.WithOutname("nocookie.bin"), rain.WithRetryNumber(1)).Run()
+	if err == nil {
+		t.Fatal("没有 cookie 时应该下载失败")
+	}
+}
+
+// WritePEM 写入 PEM 文件
+func WritePEM(t *testing.T, path, typ string, der []byte) {
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTLS 测试证书验证、CA 证书、客户端证书和公钥固定
+func TestTLS(t *testing.T) {
+	Init()
+	data := RandomData(128 << 10)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/mtls.bin") && len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		ServeData(t, w, r, data)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	WritePEM(t, caFile, "CERTIFICATE", server.Certificate().Raw)
+
+	// 客户端证书
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "rain"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	WritePEM(t, certFile, "CERTIFICATE", der)
+	WritePEM(t, keyFile, "EC PRIVATE KEY", keyDer)
+
+	sum := sha256.Sum256(server.Certificate().RawSubjectPublicKeyInfo)
+	pin := "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+
+	data2 := []struct {
+		name string
+		opts []rain.OptionFunc
+		ok   bool
+	}{
+		{"default.bin", nil, false},
+		{"insecure.bin", []rain.OptionFunc{rain.WithInsecureSkipVerify(true)}, true},
+		{"ca.bin", []rain.OptionFunc{rain.WithCACertFile(caFile)}, true},
+		{"pin.bin", []rain.OptionFunc{rain.WithCACertFile(caFile), rain.WithPinnedSPKI(pin)}, true},
+		{"badpin.bin", []rain.OptionFunc{rain.WithInsecureSkipVerify(true), rain.WithPinnedSPKI("AAAA")}, false},
+		{"mtls.bin", []rain.OptionFunc{rain.WithCACertFile(caFile)}, false},
+		{"mtls.bin", []rain.OptionFunc{rain.WithCACertFile(caFile), rain.WithClientCert(certFile, keyFile)}, true},
+		{"nocert.bin", []rain.OptionFunc{rain.WithClientCert(filepath.Join(dir, "none.pem"), keyFile)}, false},
+	}
+	for _, v := range data2 {
+		opts := append([]rain.OptionFunc{rain.WithRetryNumber(1), rain.WithAllowOverwrite(true)}, v.opts...)
+		ctl, err := rain.New(server.URL+"/"+v.name, opts...).Run()
+		if (err == nil) != v.ok {
+			t.Fatal(v.name, err)
+		}
+		if v.ok && FileMD5(ctl.Outpath()) != MD5(data) {
+			t.Fatal(v.name, "md5 错误")
+		}
+		if v.name == "badpin.bin" && !errors.Is(err, rain.ErrPinMismatch) {
+			t.Fatal("公钥不匹配时的错误错误", err)
+		}
+	}
+
+	// 默认请求客户端的设置不修改调用者传入的配置
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	r := rain.NewRain()
+	r.SetOutdir("./tmp")
+	r.SetTLSConfig(cfg)
+	if err = r.SetInsecureSkipVerify(true); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.SetTLSMinVersion(tls.VersionTLS13); err != nil {
+		t.Fatal(err)
+	}
+	ctl, err := r.New(server.URL+"/shared.bin", rain.WithRetryNumber(1)).Run()
+	if err != nil || FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("默认请求客户端的 TLS 设置错误", err)
+	}
+	if cfg.InsecureSkipVerify || cfg.MinVersion != tls.VersionTLS12 {
+		t.Fatal("修改了调用者传入的 TLS 配置")
+	}
+}
+
+// Socks5Server 需要用户名密码认证的 socks5 代理
+func Socks5Server(t *testing.T, user, pass string, count *int32) net.Listener {
+	return TcpServer(t, func(conn net.Conn) {
+		defer conn.Close()
+		buf := make([]byte, 512)
+		// 认证方式协商，只支持用户名密码
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil || buf[0] != 5 {
+			return
+		}
+		io.ReadFull(conn, buf[:buf[1]])
+		conn.Write([]byte{5, 2})
+		// 用户名密码认证
+		io.ReadFull(conn, buf[:2])
+		u := make([]byte, buf[1])
+		io.ReadFull(conn, u)
+		io.ReadFull(conn, buf[:1])
+		p := make([]byte, buf[0])
+		io.ReadFull(conn, p)
+		if string(u) != user || string(p) != pass {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+		// CONNECT 请求
+		io.ReadFull(conn, buf[:4])
+		var host string
+		switch buf[3] {
+		case 1:
+			io.ReadFull(conn, buf[:4])
+			host = net.IP(buf[:4]).String()
+		case 3:
+			io.ReadFull(conn, buf[:1])
+			name := make([]byte, buf[0])
+			io.ReadFull(conn, name)
+			host = string(name)
+		default:
+			return
+		}
+		io.ReadFull(conn, buf[:2])
+		port := int(buf[0])<<8 | int(buf[1])
+		target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			conn.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer target.Close()
+		atomic.AddInt32(count, 1)
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		go io.Copy(target, conn)
+		io.Copy(conn, target)
+	})
+}
+
+// HttpProxyServer 需要认证的 http 代理
+func HttpProxyServer(t *testing.T, auth string, count *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("proxy-authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)) {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		atomic.AddInt32(count, 1)
+		r.Header.Del("proxy-authorization")
+		r.RequestURI = ""
+		res, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer res.Body.Close()
+		for k, v := range res.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(res.StatusCode)
+		io.Copy(w, res.Body)
+	}))
+}
+
+// TestProxyURL 测试 socks5、http 代理认证和代理轮流使用
+func TestProxyURL(t *testing.T) {
+	Init()
+	data := RandomData(1 << 20)
+	server := NewDataServer(t, "proxy.bin", data)
+	defer server.Close()
+
+	var socksCount, httpCount1, httpCount2 int32
+	socks := Socks5Server(t, "alice", "secret", &socksCount)
+	defer socks.Close()
+	proxy1 := HttpProxyServer(t, "bob:pw1", &httpCount1)
+	defer proxy1.Close()
+	proxy2 := HttpProxyServer(t, "carol:pw2", &httpCount2)
+	defer proxy2.Close()
+
+	withAuth := func(uri, auth string) string {
+		return strings.Replace(uri, "://", "://"+auth+"@", 1)
+	}
+	data2 := []struct {
+		name    string
+		proxies []string
+		ok      bool
+	}{
+		{"socks5.bin", []string{"socks5h://alice:secret@" + socks.Addr().String()}, true},
+		{"socks5-bad.bin", []string{"socks5://alice:wrong@" + socks.Addr().String()}, false},
+		{"http-bad.bin", []string{proxy1.URL}, false},
+		{"rotation.bin", []string{withAuth(proxy1.URL, "bob:pw1"), withAuth(proxy2.URL, "carol:pw2")}, true},
+		{"scheme.bin", []string{"ftp://127.0.0.1:21"}, false},
+	}
+	for _, v := range data2 {
+		ctl, err := rain.New(
+			server.URL,
+			rain.WithOutname(v.name),
+			rain.WithProxy(v.proxies...),
+			rain.WithRetryNumber(1),
+			rain.WithRoutineCount(4),
+			rain.WithRoutineSize(256<<10),
+		).Run()
+		if (err == nil) != v.ok {
+			t.Fatal(v.name, err)
+		}
+		if v.ok && FileMD5(ctl.Outpath()) != MD5(data) {
+			t.Fatal(v.name, "md5 错误")
+		}
+	}
+	if atomic.LoadInt32(&socksCount) == 0 {
+		t.Fatal("没有使用 socks5 代理")
+	}
+	if atomic.LoadInt32(&httpCount1) == 0 || atomic.LoadInt32(&httpCount2) == 0 {
+		t.Fatal("没有轮流使用代理", httpCount1, httpCount2)
+	}
+}
+
+// TestProtocol 测试 HTTP/2 和强制 HTTP/1.1
+func TestProtocol(t *testing.T) {
+	Init()
+	data := RandomData(1 << 20)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeData(t, w, r, data)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	data2 := map[rain.Protocol]string{
+		rain.PROTOCOL_AUTO:  "HTTP/2.0",
+		rain.PROTOCOL_HTTP1: "HTTP/1.1",
+		rain.PROTOCOL_HTTP2: "HTTP/2.0",
+	}
+	for protocol, proto := range data2 {
+		event := &TotalEvent{}
+		ctl, err := rain.New(
+			server.URL+"/protocol.bin",
+			rain.WithOutname(fmt.Sprintf("protocol_%d.bin", protocol)),
+			rain.WithInsecureSkipVerify(true),
+			rain.WithProtocol(protocol),
+			rain.WithRoutineCount(4),
+			rain.WithRoutineSize(256<<10),
+			rain.WithEvent(event),
+		).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if FileMD5(ctl.Outpath()) != MD5(data) {
+			t.Fatal("md5 错误")
+		}
+		if event.Proto != proto {
+			t.Fatal("协议错误", protocol, event.Proto)
+		}
+	}
+}
+
+// TestSetProtocol 测试默认请求客户端使用过之后切换协议
+func TestSetProtocol(t *testing.T) {
+	Init()
+	data := RandomData(256 << 10)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeData(t, w, r, data)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	r := rain.NewRain()
+	r.SetOutdir("./tmp")
+	if err := r.SetInsecureSkipVerify(true); err != nil {
+		t.Fatal(err)
+	}
+	data2 := []struct {
+		protocol rain.Protocol
+		proto    string
+	}{
+		{rain.PROTOCOL_AUTO, "HTTP/2.0"},
+		{rain.PROTOCOL_HTTP1, "HTTP/1.1"},
+		{rain.PROTOCOL_HTTP2, "HTTP/2.0"},
+		{rain.PROTOCOL_HTTP1, "HTTP/1.1"},
+	}
+	for i, v := range data2 {
+		r.SetProtocol(v.protocol)
+		event := &TotalEvent{}
+		ctl, err := r.New(
+			server.URL+"/switch.bin",
+			rain.WithOutname(fmt.Sprintf("switch_%d.bin", i)),
+			rain.WithRoutineCount(2),
+			rain.WithRoutineSize(64<<10),
+			rain.WithEvent(event),
+		).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if FileMD5(ctl.Outpath()) != MD5(data) {
+			t.Fatal("md5 错误")
+		}
+		if event.Proto != v.proto {
+			t.Fatal("切换协议后协议错误", i, event.Proto)
+		}
+	}
+}
+
+// TestRoutineIdleConns 测试当前下载的协程数量多于连接池时，下载块复用连接
+func TestRoutineIdleConns(t *testing.T) {
+	Init()
+	var conns int32
+	data := RandomData(4 << 20)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeData(t, w, r, data)
+	}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	r := rain.NewRain()
+	r.SetOutdir("./tmp")
+	ctl, err := r.New(
+		server.URL+"/conns.bin",
+		rain.WithRoutineCount(16),
+		rain.WithRoutineSize(16<<10),
+	).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("md5 错误")
+	}
+	// 连接可以复用时建立的连接数量接近协程数量，不会随着下载块的数量增加
+	if n := atomic.LoadInt32(&conns); n > 24 {
+		t.Fatal("下载块没有复用连接", n)
+	}
+}
+
+// TestTransportIdleConns 测试下载和探测结束后关闭复制的 transport 的闲置连接
+func TestTransportIdleConns(t *testing.T) {
+	Init()
+	var open int32
+	data := RandomData(1 << 20)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeData(t, w, r, data)
+	}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			atomic.AddInt32(&open, 1)
+		case http.StateClosed, http.StateHijacked:
+			atomic.AddInt32(&open, -1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	waitClosed := func(name string) {
+		deadline := time.Now().Add(2 * time.Second)
+		for atomic.LoadInt32(&open) != 0 {
+			if time.Now().After(deadline) {
+				t.Fatal(name, "没有关闭闲置连接", atomic.LoadInt32(&open))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	ctl, err := rain.New(
+		server.URL+"/idle.bin",
+		rain.WithProtocol(rain.PROTOCOL_HTTP1),
+		rain.WithRoutineCount(4),
+		rain.WithRoutineSize(256<<10),
+	).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("md5 错误")
+	}
+	waitClosed("下载")
+
+	_, err = rain.Probe(context.Background(), server.URL+"/idle.bin", rain.WithProtocol(rain.PROTOCOL_HTTP1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitClosed("探测")
+}
+
+// TestDialer 测试绑定本地地址和自定义域名解析
+func TestDialer(t *testing.T) {
+	Init()
+	var (
+		mux     sync.Mutex
+		remotes = make(map[string]bool)
+		data    = RandomData(256 << 10)
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		mux.Lock()
+		remotes[host] = true
+		mux.Unlock()
+		ServeData(t, w, r, data)
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	uri := "http://download.rain.test:" + port + "/dialer.bin"
+
+	var lookups int32
+	data2 := []struct {
+		name string
+		uri  string
+		opts []rain.OptionFunc
+		ok   bool
+	}{
+		{"resolve.bin", uri, []rain.OptionFunc{rain.WithResolve("download.rain.test", "127.0.0.1")}, true},
+		{"resolve-port.bin", uri, []rain.OptionFunc{rain.WithResolve("download.rain.test:"+port, "127.0.0.1")}, true},
+		{"resolver.bin", uri, []rain.OptionFunc{rain.WithResolver(rain.ResolverFunc(func(ctx context.Context, host string) ([]string, error) {
+			atomic.AddInt32(&lookups, 1)
+			return []string{"127.0.0.1"}, nil
+		}))}, true},
+		{"local.bin", server.URL + "/dialer.bin", []rain.OptionFunc{rain.WithLocalAddr("127.0.0.2")}, true},
+		{"bad-local.bin", server.URL + "/dialer.bin", []rain.OptionFunc{rain.WithLocalAddr("local")}, false},
+		{"bad-iface.bin", server.URL + "/dialer.bin", []rain.OptionFunc{rain.WithInterface("rain-none0")}, false},
+	}
+	for _, v := range data2 {
+		opts := append([]rain.OptionFunc{rain.WithOutname(v.name), rain.WithRetryNumber(1)}, v.opts...)
+		ctl, err := rain.New(v.uri, opts...).Run()
+		if (err == nil) != v.ok {
+			t.Fatal(v.name, err)
+		}
+		if v.ok && FileMD5(ctl.Outpath()) != MD5(data) {
+			t.Fatal(v.name, "md5 错误")
+		}
+	}
+	if atomic.LoadInt32(&lookups) == 0 {
+		t.Fatal("没有使用自定义域名解析")
+	}
+	if !remotes["127.0.0.2"] {
+		t.Fatal("没有绑定本地地址", remotes)
+	}
+}
+
+// TestRedirect 测试跳转策略和跳转后的链接
+func TestRedirect(t *testing.T) {
+	Init()
+	var (
+		data       = RandomData(1 << 20)
+		starts     int32
+		gen        int32 = 1
+		expired    int32
+		crossAuth  int32
+		entries    int32
+		authHeader = "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+	)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("authorization") != "" || r.Header.Get("cookie") != "" {
+			atomic.AddInt32(&crossAuth, 1)
+		}
+		ServeData(t, w, r, data)
+	}))
+	defer other.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/start":
+			atomic.AddInt32(&starts, 1)
+			http.Redirect(w, r, "/final.bin", http.StatusFound)
+		case r.URL.Path == "/final.bin":
+			if r.Header.Get("authorization") != authHeader {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			ServeData(t, w, r, data)
+		case r.URL.Path == "/other":
+			atomic.AddInt32(&entries, 1)
+			http.Redirect(w, r, other.URL+"/other.bin", http.StatusFound)
+		case strings.HasPrefix(r.URL.Path, "/chain/"):
+			if r.URL.Path == "/chain/3" {
+				atomic.AddInt32(&entries, 1)
+			}
+			n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/chain/"))
+			if n == 0 {
+				ServeData(t, w, r, data)
+				return
+			}
+			http.Redirect(w, r, fmt.Sprintf("/chain/%d", n-1), http.StatusFound)
+		case r.URL.Path == "/expire":
+			http.Redirect(w, r, fmt.Sprintf("/token/%d/expire.bin", atomic.LoadInt32(&gen)), http.StatusFound)
+		case strings.HasPrefix(r.URL.Path, "/token/"):
+			// 第一个链接在第一个下载块请求之后失效，之后的链接一直有效
+			hrange := r.Header.Get("range")
+			if r.URL.Path == "/token/1/expire.bin" && hrange != "" && hrange != fmt.Sprintf("bytes=0-%d", rain.PROBE_SNIFF_SIZE-1) &&
+				!atomic.CompareAndSwapInt32(&gen, 1, 2) {
+				atomic.AddInt32(&expired, 1)
+				w.WriteHeader(http.StatusGone)
+				return
+			}
+			ServeData(t, w, r, data)
+		}
+	}))
+	defer server.Close()
+
+	opts := []rain.OptionFunc{
+		rain.WithRetryNumber(1),
+		rain.WithRoutineCount(2),
+		rain.WithRoutineSize(256 << 10),
+	}
+
+	// 下载块直接请求跳转后的链接，相同主机携带认证信息
+	ctl, err := rain.New(server.URL+"/start", append(opts, rain.WithBasicAuth("alice", "secret"))...).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("md5 错误")
+	}
+	if ctl.FinalURI() != server.URL+"/final.bin" || atomic.LoadInt32(&starts) != 1 {
+		t.Fatal("没有直接请求跳转后的链接", ctl.FinalURI(), starts)
+	}
+
+	// 跳转到其他主机时不携带认证信息和 cookie
+	_, err = rain.New(server.URL+"/other", append(opts,
+		rain.WithBasicAuth("alice", "secret"),
+		rain.WithHeader(func(h http.Header) { h.Set("cookie", "SESSION=secret") }),
+	)...).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&crossAuth) != 0 {
+		t.Fatal("跳转到其他主机时携带了认证信息或者 cookie")
+	}
+
+	// 跳转后的链接失效时使用原始链接
+	ctl, err = rain.New(server.URL+"/expire", opts...).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("跳转后的链接失效 md5 错误")
+	}
+	if atomic.LoadInt32(&expired) == 0 {
+		t.Fatal("跳转后的链接没有失效")
+	}
+
+	// 跳转次数和跳转策略，被拒绝时不重试
+	data2 := []struct {
+		uri  string
+		opts []rain.OptionFunc
+		err  error
+	}{
+		{server.URL + "/chain/3", []rain.OptionFunc{rain.WithMaxRedirects(3)}, nil},
+		{server.URL + "/chain/3", []rain.OptionFunc{rain.WithMaxRedirects(2)}, rain.ErrTooManyRedirects},
+		{server.URL + "/other", []rain.OptionFunc{rain.WithRedirectPolicy(rain.AllowRedirectDomains("example.com"))}, rain.ErrRedirectDenied},
+	}
+	for _, v := range data2 {
+		atomic.StoreInt32(&entries, 0)
+		_, err = rain.New(v.uri, append(opts, append(v.opts, rain.WithRetryNumber(3))...)...).Run()
+		if v.err == nil && err != nil || v.err != nil && !errors.Is(err, v.err) {
+			t.Fatal(v.uri, err)
+		}
+		if v.err != nil && atomic.LoadInt32(&entries) != 1 {
+			t.Fatal("跳转被拒绝后重试了请求", v.uri, entries)
+		}
+	}
+}
+
+// TestResumeFinalURI 测试续传时使用断点中记录的跳转后的链接，链接失效时使用原始链接
+func TestResumeFinalURI(t *testing.T) {
+	Init()
+	var (
+		data    = RandomData(1 << 20)
+		half    = len(data) / 2
+		gen     int32
+		expired int32
+		hits    sync.Map
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/resume" {
+			http.Redirect(w, r, fmt.Sprintf("/token/%d/resume.bin", atomic.AddInt32(&gen, 1)), http.StatusFound)
+			return
+		}
+		if r.URL.Path == "/token/1/resume.bin" && atomic.LoadInt32(&expired) == 1 {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		if r.Header.Get("range") != "" && !strings.HasPrefix(r.Header.Get("range"), "bytes=0-") {
+			n, _ := hits.LoadOrStore(r.URL.Path, new(int32))
+			atomic.AddInt32(n.(*int32), 1)
+		}
+		ServeData(t, w, r, data)
+	}))
+	defer server.Close()
+
+	info, err := rain.Probe(context.Background(), server.URL+"/resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 已经下载了前一半，断点记录第一次跳转后的链接
+	writeBreakpoint := func() {
+		err := os.WriteFile("./tmp/resume.bin", data[:half], 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bp, _ := json.Marshal(map[string]interface{}{
+			"filesize":     len(data),
+			"etag":         MD5(data),
+			"content_type": info.ContentType,
+			"final_uri":    info.FinalURI,
+			"position":     len(data),
+			"tasks":        []map[string]int{{"start": half, "end": len(data) - 1}},
+		})
+		err = os.WriteFile("./tmp/resume.bin.temp.rain", bp, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Mkdir("./tmp", os.ModePerm)
+	opts := []rain.OptionFunc{
+		rain.WithOutname("resume.bin"),
+		rain.WithRetryNumber(1),
+		rain.WithRoutineCount(2),
+		rain.WithRoutineSize(256 << 10),
+	}
+
+	writeBreakpoint()
+	ctl, err := rain.New(server.URL+"/resume", opts...).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("md5 错误")
+	}
+	count := func(path string) int32 {
+		n, ok := hits.Load(path)
+		if !ok {
+			return 0
+		}
+		return atomic.LoadInt32(n.(*int32))
+	}
+	if count("/token/1/resume.bin") == 0 || count("/token/2/resume.bin") != 0 {
+		t.Fatal("续传时没有使用断点中的链接", count("/token/1/resume.bin"), count("/token/2/resume.bin"))
+	}
+
+	// 断点中的链接失效
+	atomic.StoreInt32(&expired, 1)
+	writeBreakpoint()
+	ctl, err = rain.New(server.URL+"/resume", opts...).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("链接失效时 md5 错误")
+	}
+}
+
+// TestResumeOldBreakpoint 测试从没有记录最后修改时间和资源类型的旧格式断点文件续传
+func TestResumeOldBreakpoint(t *testing.T) {
+	Init()
+	var (
+		data      = RandomData(1 << 20)
+		half      = len(data) / 2
+		requested int64
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("last-modified", "Wed, 21 Oct 2015 07:28:00 GMT")
+		w.Header().Set("content-type", "application/octet-stream")
+		hrange := r.Header.Get("range")
+		if hrange != "" && hrange != fmt.Sprintf("bytes=0-%d", rain.PROBE_SNIFF_SIZE-1) {
+			var start, end int64
+			fmt.Sscanf(hrange, "bytes=%d-%d", &start, &end)
+			atomic.AddInt64(&requested, end-start+1)
+		}
+		ServeData(t, w, r, data)
+	}))
+	defer server.Close()
+
+	os.Mkdir("./tmp", os.ModePerm)
+	err := os.WriteFile("./tmp/old.bin", data[:half], 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp := fmt.Sprintf(`{"filesize":%d,"etag":%q,"position":%d,"tasks":[{"start":%d,"end":%d}]}`,
+		len(data), MD5(data), len(data), half, len(data)-1)
+	err = os.WriteFile("./tmp/old.bin.temp.rain", []byte(bp), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctl, err := rain.New(server.URL+"/old.bin", rain.WithOutname("old.bin")).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("md5 错误")
+	}
+	if n := atomic.LoadInt64(&requested); n != int64(len(data)-half) {
+		t.Fatal("没有从旧格式的断点续传", n)
+	}
+}
+
+func TestDenyDowngrade(t *testing.T) {
+	Init()
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeData(t, w, r, []byte("rain"))
+	}))
+	defer plain.Close()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, plain.URL+"/plain.txt", http.StatusFound)
+	}))
+	defer server.Close()
+
+	_, err := rain.New(
+		server.URL+"/secure.txt",
+		rain.WithInsecureSkipVerify(true),
+		rain.WithRetryNumber(1),
+		rain.WithRedirectPolicy(rain.DenyDowngrade()),
+	).Run()
+	if !errors.Is(err, rain.ErrRedirectDenied) {
+		t.Fatal("没有拒绝跳转到 http", err)
+	}
+}
+
+// TestFilenameResolver 测试自动获取文件名
+func TestFilenameResolver(t *testing.T) {
+	Init()
+	data := RandomData(1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/download":
+			http.Redirect(w, r, "/files/%E4%B8%AD%E6%96%87.bin", http.StatusFound)
+		case "/attachment":
+			w.Header().Set("content-disposition", `attachment; filename="fallback.bin"; filename*=UTF-8''%E9%99%84%E4%BB%B6.bin`)
+			ServeData(t, w, r, data)
+		case "/cover":
+			w.Header().Set("content-type", "image/jpeg")
+			ServeData(t, w, r, data)
+		default:
+			ServeData(t, w, r, data)
+		}
+	}))
+	defer server.Close()
+
+	testData := []struct {
+		uri  string
+		opts []rain.OptionFunc
+		name string
+	}{
+		{server.URL + "/download", nil, "中文.bin"},
+		{server.URL + "/attachment", nil, "附件.bin"},
+		{server.URL + "/cover", nil, "cover.jpg"},
+		{server.URL + "/custom", []rain.OptionFunc{rain.WithFilenameResolver(rain.FilenameResolverFunc(func(info *rain.ResourceInfo) string {
+			return "custom-" + strings.TrimPrefix(info.FinalURI, server.URL+"/") + ".bin"
+		}))}, "custom-custom.bin"},
+	}
+	for _, v := range testData {
+		ctl, err := rain.New(v.uri, append(v.opts, rain.WithOutdir("./tmp"))...).Run()
+		if err != nil {
+			t.Fatal(v.uri, err)
+		}
+		if filepath.Base(ctl.Outpath()) != v.name {
+			t.Fatal("文件名错误", v.uri, ctl.Outpath())
+		}
+	}
+}
+
+// TestOutTemplate 测试输出路径模板
+func TestOutTemplate(t *testing.T) {
+	Init()
+	data := RandomData(1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-disposition", `attachment; filename="a:b.bin"`)
+		ServeData(t, w, r, data)
+	}))
+	defer server.Close()
+
+	date := time.Now().Format("2006-01-02")
+	testData := []struct {
+		opts []rain.OptionFunc
+		path string
+	}{
+		{[]rain.OptionFunc{rain.WithOutTemplate("{{.Host}}/{{.Date}}/{{.Name}}{{.Ext}}")}, "127.0.0.1/" + date + "/ab.bin"},
+		{[]rain.OptionFunc{rain.WithOutTemplate("{{.Dir}}/{{.Size}}-{{.Filename}}")}, "archive/2022/1024-ab.bin"},
+		{[]rain.OptionFunc{rain.WithOutTemplate("{{.Host}}/{{.Filename}}"), rain.WithOutname("fixed.bin")}, "fixed.bin"},
+	}
+	for _, v := range testData {
+		ctl, err := rain.New(server.URL+"/archive/2022/file", append(v.opts, rain.WithOutdir("./tmp"))...).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := filepath.Abs(filepath.Join("./tmp", v.path))
+		if ctl.Outpath() != want {
+			t.Fatal("输出路径错误", ctl.Outpath(), want)
+		}
+		if FileMD5(ctl.Outpath()) != MD5(data) {
+			t.Fatal("md5 错误")
+		}
+	}
+
+	_, err := rain.New(server.URL+"/file", rain.WithOutdir("./tmp"), rain.WithOutTemplate("{{.Host")).Run()
+	if err == nil {
+		t.Fatal("模板错误时没有返回错误")
+	}
+}
+
+// TestFilenamePolicy 测试文件名过滤规则
+func TestFilenamePolicy(t *testing.T) {
+	Init()
+	data := RandomData(1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-disposition", `attachment; filename="nul: a?.txt"`)
+		ServeData(t, w, r, data)
+	}))
+	defer server.Close()
+
+	testData := []struct {
+		opts []rain.OptionFunc
+		name string
+	}{
+		{nil, "nul a.txt"},
+		{[]rain.OptionFunc{rain.WithFilenamePolicy(rain.FILENAME_PORTABLE)}, "nul_ a_.txt"},
+		{[]rain.OptionFunc{rain.WithFilenamePolicy(rain.FILENAME_WINDOWS), rain.WithFilenameReplacement("")}, "nul a.txt"},
+	}
+	for _, v := range testData {
+		ctl, err := rain.New(server.URL+"/file", append(v.opts, rain.WithOutdir("./tmp"), rain.WithAllowOverwrite(true))...).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Base(ctl.Outpath()) != v.name {
+			t.Fatal("文件名错误", ctl.Outpath(), v.name)
+		}
+	}
+}
+
+// TestRenameStrategy 测试自动重命名规则和并发下载时的文件名冲突
+func TestRenameStrategy(t *testing.T) {
+	Init()
+	data := RandomData(64 << 10)
+	server := NewDataServer(t, "file.bin", data)
+	defer server.Close()
+	os.MkdirAll("./tmp", os.ModePerm)
+	os.WriteFile("./tmp/file.bin", nil, os.ModePerm)
+
+	ctl, err := rain.New(server.URL, rain.WithOutdir("./tmp"), rain.WithRenameStrategy(rain.RenameParenNumber())).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(ctl.Outpath()) != "file (1).bin" || FileMD5(ctl.Outpath()) != MD5(data) {
+		t.Fatal("重命名错误", ctl.Outpath())
+	}
+
+	// 自定义规则不能跳出输出目录
+	ctl, err = rain.New(server.URL, rain.WithOutdir("./tmp"), rain.WithRenameStrategy(func(name string, attempt int) string {
+		return fmt.Sprintf("../custom-%d-%s", attempt, name)
+	})).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := filepath.Abs("./tmp/custom-1-file.bin"); ctl.Outpath() != want {
+		t.Fatal("自定义重命名错误", ctl.Outpath())
+	}
+
+	// 超过最多次数
+	os.WriteFile("./tmp/file.1.bin", nil, os.ModePerm)
+	os.WriteFile("./tmp/file.2.bin", nil, os.ModePerm)
+	_, err = rain.New(server.URL, rain.WithOutdir("./tmp"), rain.WithRenameMaxAttempts(2)).Run()
+	var renameErr *rain.RenameError
+	if !errors.As(err, &renameErr) || renameErr.Attempts != 2 || !errors.Is(err, os.ErrExist) {
+		t.Fatal("没有返回重命名错误", err)
+	}
+
+	// 不允许重命名
+	_, err = rain.New(server.URL, rain.WithOutdir("./tmp"), rain.WithAutoFileRenaming(false)).Run()
+	if !errors.Is(err, os.ErrExist) {
+		t.Fatal("文件已经存在时没有返回错误", err)
+	}
+
+	// 并发下载到同一个目录时不会使用相同的文件名
+	Init()
+	var (
+		wg    sync.WaitGroup
+		mux   sync.Mutex
+		paths = make(map[string]bool)
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctl, err := rain.New(server.URL, rain.WithOutdir("./tmp")).Run()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mux.Lock()
+			paths[ctl.Outpath()] = true
+			mux.Unlock()
+		}()
+	}
+	wg.Wait()
+	if len(paths) != 8 {
+		t.Fatal("并发下载使用了相同的文件名", paths)
+	}
+	for path := range paths {
+		if FileMD5(path) != MD5(data) {
+			t.Fatal("md5 错误", path)
+		}
+	}
+}
+
+// TestOutpathRelease 测试下载结束的处理完成之前，其他下载不能使用同一个输出路径
+func TestOutpathRelease(t *testing.T) {
+	Init()
+	data := RandomData(1 << 20)
+	server := NewDataServer(t, "release.bin", data)
+	defer server.Close()
+
+	var inner *rain.RainControl
+	ctl, err := rain.New(server.URL,
+		rain.WithOutdir("./tmp"),
+		rain.WithHook(&rain.HookFuncs{
+			AfterFinishFunc: func(h *rain.HookContext, err error) error {
+				// 结束处理中启动相同文件名的下载
+				var ierr error
+				inner, ierr = rain.New(server.URL, rain.WithOutdir("./tmp")).Run()
+				if ierr != nil {
+					t.Error(ierr)
+				}
+				return err
+			},
+		}),
+	).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inner == nil || inner.Outpath() == ctl.Outpath() {
+		t.Fatal("结束处理中的输出路径被其他下载使用")
+	}
+	if FileMD5(ctl.Outpath()) != MD5(data) || FileMD5(inner.Outpath()) != MD5(data) {
+		t.Fatal("md5 错误")
+	}
+}
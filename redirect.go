@@ -0,0 +1,70 @@
+package rain
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// RedirectPolicy 判断是否允许跳转，返回错误时停止跳转，req 为即将发送的请求，via 为之前的请求
+type RedirectPolicy func(req *http.Request, via []*http.Request) error
+
+// DenyDowngrade 不允许从 https 跳转到 http
+func DenyDowngrade() RedirectPolicy {
+	return func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme == "http" && via[len(via)-1].URL.Scheme == "https" {
+			return fmt.Errorf("%w: downgrade to %s", ErrRedirectDenied, req.URL.Redacted())
+		}
+		return nil
+	}
+}
+
+// AllowRedirectDomains 只允许跳转到列表中的域名及其子域名
+func AllowRedirectDomains(domains ...string) RedirectPolicy {
+	return func(req *http.Request, via []*http.Request) error {
+		host := strings.ToLower(req.URL.Hostname())
+		for _, v := range domains {
+			v = strings.ToLower(strings.TrimPrefix(v, "."))
+			if host == v || strings.HasSuffix(host, "."+v) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s not allowed", ErrRedirectDenied, host)
+	}
+}
+
+// checkRedirect 限制跳转次数并执行跳转策略
+// 跳转到相同的主机时携带认证信息，跳转到其他主机时删除认证信息和 cookie
+func (ctl *control) checkRedirect(next func(req *http.Request, via []*http.Request) error) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > ctl.config.MaxRedirects {
+			return fmt.Errorf("%w: stopped after %d redirects", ErrTooManyRedirects, ctl.config.MaxRedirects)
+		}
+		for _, v := range ctl.redirectPolicies {
+			if err := v(req, via); err != nil {
+				return err
+			}
+		}
+		if strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+			if auth := via[0].Header.Get("authorization"); auth != "" {
+				req.Header.Set("authorization", auth)
+			}
+		} else {
+			delSensitiveHeader(req.Header)
+		}
+		if next != nil {
+			return next(req, via)
+		}
+		return nil
+	}
+}
+
+// sensitiveHeaders 跳转到其他主机时不携带的请求头，与 net/http 跳转时删除的请求头相同
+var sensitiveHeaders = []string{"authorization", "www-authenticate", "cookie", "cookie2"}
+
+// delSensitiveHeader 删除认证信息和 cookie
+func delSensitiveHeader(h http.Header) {
+	for _, key := range sensitiveHeaders {
+		h.Del(key)
+	}
+}
@@ -0,0 +1,163 @@
+package rain
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RenameStrategy 自动重命名时生成新的文件名，attempt 为重命名的次数，从 1 开始
+type RenameStrategy func(name string, attempt int) string
+
+// RenameError 自动重命名达到最大次数后仍然没有找到可以使用的文件名
+type RenameError struct {
+	// Dir 输出目录
+	Dir string
+	// Name 原始文件名
+	Name string
+	// Attempts 重命名的次数
+	Attempts int
+}
+
+// Error 错误信息
+func (e *RenameError) Error() string {
+	return fmt.Sprintf("rename %s: no available name after %d attempts", filepath.Join(e.Dir, e.Name), e.Attempts)
+}
+
+// Unwrap 与不允许重命名时一样，可以使用 errors.Is(err, os.ErrExist) 判断
+func (e *RenameError) Unwrap() error {
+	return os.ErrExist
+}
+
+// splitExt 拆分文件名和扩展名
+func splitExt(name string) (string, string) {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext), ext
+}
+
+// RenameDotNumber 在名称之后扩展名之前加上一个点和一个数字，例如 name.1.ext，默认的重命名规则
+func RenameDotNumber() RenameStrategy {
+	return func(name string, attempt int) string {
+		base, ext := splitExt(name)
+		return fmt.Sprintf("%s.%d%s", base, attempt, ext)
+	}
+}
+
+// RenameParenNumber 在名称之后扩展名之前加上括号和数字，例如 name (1).ext
+func RenameParenNumber() RenameStrategy {
+	return func(name string, attempt int) string {
+		base, ext := splitExt(name)
+		return fmt.Sprintf("%s (%d)%s", base, attempt, ext)
+	}
+}
+
+// RenameTimestamp 在名称之后扩展名之前加上当前时间，例如 name.20060102150405.ext，同一秒内再次重命名时加上数字
+func RenameTimestamp() RenameStrategy {
+	return func(name string, attempt int) string {
+		base, ext := splitExt(name)
+		suffix := time.Now().Format("20060102150405")
+		if attempt > 1 {
+			suffix = fmt.Sprintf("%s-%d", suffix, attempt)
+		}
+		return fmt.Sprintf("%s.%s%s", base, suffix, ext)
+	}
+}
+
+// RenameHash 在名称之后扩展名之前加上 8 位哈希，例如 name.1a2b3c4d.ext，哈希由文件名、次数和当前时间生成
+func RenameHash() RenameStrategy {
+	return func(name string, attempt int) string {
+		base, ext := splitExt(name)
+		sum := sha1.Sum([]byte(fmt.Sprintf("%s\x00%d\x00%d", name, attempt, time.Now().UnixNano())))
+		return fmt.Sprintf("%s.%s%s", base, hex.EncodeToString(sum[:4]), ext)
+	}
+}
+
+// createExclusive 独占创建文件，文件已经存在时返回 os.ErrExist
+func createExclusive(path string, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+}
+
+// activeOutpaths 同一个进程中正在进行的下载占用的输出路径
+var activeOutpaths = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: make(map[string]bool)}
+
+// claimOutpath 占用输出路径，已经被其他下载占用时返回 false
+func (ctl *control) claimOutpath(path string) bool {
+	if path == ctl.claimedOutpath {
+		return true
+	}
+	activeOutpaths.Lock()
+	defer activeOutpaths.Unlock()
+	if activeOutpaths.paths[path] {
+		return false
+	}
+	if ctl.claimedOutpath != "" {
+		delete(activeOutpaths.paths, ctl.claimedOutpath)
+	}
+	activeOutpaths.paths[path] = true
+	ctl.claimedOutpath = path
+	return true
+}
+
+// releaseOutpath 释放占用的输出路径
+func (ctl *control) releaseOutpath() {
+	if ctl.claimedOutpath == "" {
+		return
+	}
+	activeOutpaths.Lock()
+	delete(activeOutpaths.paths, ctl.claimedOutpath)
+	activeOutpaths.Unlock()
+	ctl.claimedOutpath = ""
+}
+
+// reserveOutfile 独占创建输出文件，文件已经存在并且开启自动重命名时按照重命名规则寻找可以使用的文件名
+// 独占创建保证同一个目录中同时进行的下载不会使用相同的文件名
+func (ctl *control) reserveOutfile() (*os.File, error) {
+	if ctl.claimedOutpath == ctl.outpath {
+		f, err := createExclusive(ctl.outpath, ctl.perm)
+		if err == nil || !errors.Is(err, os.ErrExist) {
+			return f, err
+		}
+	}
+	if !ctl.config.AutoFileRenaming {
+		return nil, os.ErrExist
+	}
+	strategy := ctl.config.RenameStrategy
+	if strategy == nil {
+		strategy = RenameDotNumber()
+	}
+	for attempt := 1; attempt <= ctl.config.RenameMaxAttempts; attempt++ {
+		// 自定义的规则不能跳出输出目录
+		outname := ctl.filterFilename(baseFilename(strategy(ctl.outname, attempt)))
+		if outname == "" || outname == ctl.outname {
+			continue
+		}
+		outpath, err := filepath.Abs(filepath.Join(ctl.outdir, outname))
+		if err != nil {
+			return nil, err
+		}
+		if !ctl.claimOutpath(outpath) {
+			continue
+		}
+		f, err := createExclusive(outpath, ctl.perm)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ctl.outname = outname
+		ctl.outpath = outpath
+		ctl.bpfilepath = filepath.Join(ctl.outdir, outname+ctl.config.BreakpointExt)
+		return f, nil
+	}
+	return nil, &RenameError{Dir: ctl.outdir, Name: ctl.outname, Attempts: ctl.config.RenameMaxAttempts}
+}
@@ -0,0 +1,30 @@
+package rain
+
+import (
+	"regexp"
+	"testing"
+)
+
+// TestRenameStrategy 测试重命名规则
+func TestRenameStrategy(t *testing.T) {
+	testData := []struct {
+		strategy RenameStrategy
+		attempt  int
+		pattern  string
+	}{
+		{RenameDotNumber(), 1, `^file\.1\.bin$`},
+		{RenameParenNumber(), 2, `^file \(2\)\.bin$`},
+		{RenameTimestamp(), 1, `^file\.\d{14}\.bin$`},
+		{RenameTimestamp(), 3, `^file\.\d{14}-3\.bin$`},
+		{RenameHash(), 1, `^file\.[0-9a-f]{8}\.bin$`},
+	}
+	for key, v := range testData {
+		name := v.strategy("file.bin", v.attempt)
+		if !regexp.MustCompile(v.pattern).MatchString(name) {
+			t.Errorf("%d 文件名错误: %s", key, name)
+		}
+	}
+	if name := RenameParenNumber()("README", 1); name != "README (1)" {
+		t.Errorf("没有扩展名时文件名错误: %s", name)
+	}
+}
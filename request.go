@@ -2,13 +2,16 @@ package rain
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"path/filepath"
+	"net/http/httptrace"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/h2non/filetype"
@@ -34,100 +37,219 @@ type request struct {
 	retryNumber int
 	// retryTime 重试间隔时间
 	retryTime time.Duration
+	// connectTimeout 建立连接的超时时间
+	connectTimeout time.Duration
+	// responseHeaderTimeout 建立连接后等待响应头的超时时间
+	responseHeaderTimeout time.Duration
+	// ifRange range 请求时携带的 If-Range，资源变化时服务器返回完整内容
+	ifRange string
+	// acceptEncoding 下载完整内容时携带的 accept-encoding，为空时不请求压缩传输
+	acceptEncoding string
+	// finalURI 探测资源时跳转后的链接，下载块直接请求该链接
+	finalURI string
+	// signers 请求签名
+	signers []RequestSigner
+	// filenameResolver 自定义的文件名获取规则
+	filenameResolver FilenameResolver
+	// beforeRequest 下载块发送请求之前执行
+	beforeRequest func(req *http.Request) error
+	// ifNoneMatch 探测资源时携带的 If-None-Match
+	ifNoneMatch string
+	// ifModifiedSince 探测资源时携带的 If-Modified-Since
+	ifModifiedSince string
 }
 
-// resourceInfo 资源信息
-type resourceInfo struct {
-	// uri 资源链接
-	uri string
-	// filesize 资源大小
-	filesize int64
-	// multithread 是否支持断点续传和多协程
-	multithread bool
-	// contentDisposition 资源描述
-	contentDisposition string
-	// extension 扩展
-	extension string
-	// etag 资源唯一标识
-	etag string
-}
-
-// getFilename 获取文件名
-func (b *resourceInfo) getFilename() (name string) {
-	func() string {
-		// 从附加信息中获取文件名
-		name = getMimeFilename(b.contentDisposition)
-		if name != "" {
-			return name
-		}
-		// 从资源链接中获取文件名
-		name = getUriFilename(b.uri)
-		if name != "" {
-			return name
-		}
-		// 随机生成名称
-		return fmt.Sprintf("file_%s%d", randomString(5, 1), time.Now().UnixNano())
-	}()
-	// 如果获取的名称没有后缀，而魔数里获取到了后缀信息，则应用魔数后缀
-	if name != "" && filepath.Ext(name) == "" && b.extension != "" {
-		name = fmt.Sprintf("%s.%s", name, b.extension)
-	}
-	return
+// PROBE_SNIFF_SIZE 探测资源时用于获取文件类型的字节数
+const PROBE_SNIFF_SIZE = 262
+
+// ResourceInfo 资源探测信息
+type ResourceInfo struct {
+	// URI 资源链接
+	URI string
+	// StatusCode 探测请求的状态码
+	StatusCode int
+	// Filesize 资源大小，未知时为 -1
+	Filesize int64
+	// Multithread 是否支持 range 请求，支持时可以断点续传和多协程下载
+	Multithread bool
+	// ContentDisposition 资源描述
+	ContentDisposition string
+	// Extension 根据魔数获取的扩展名
+	Extension string
+	// Etag 资源唯一标识
+	Etag string
+	// LastModified 资源最后修改时间
+	LastModified string
+	// ContentType 资源类型
+	ContentType string
+	// Filename 建议的文件名
+	Filename string
+	// FinalURI 跟随重定向后最终请求的资源链接
+	FinalURI string
 }
 
 // getResourceInfo 获取资源的基础信息
-func (r *request) getResourceInfo() (*resourceInfo, error) {
-	res, err := r.rangeDo(0, 261)
+// head 为 true 时先尝试 HEAD 请求，失败时使用 range 请求探测
+func (r *request) getResourceInfo(head bool) (*ResourceInfo, error) {
+	if head && r.method == http.MethodGet {
+		info, err := r.headResourceInfo()
+		if err == nil {
+			info.Filename = r.resolveFilename(info)
+			return info, nil
+		}
+		r.log("probe: head failed, fallback to range:", err)
+	}
+	info, err := r.rangeResourceInfo()
+	if err != nil {
+		return nil, err
+	}
+	info.Filename = r.resolveFilename(info)
+	return info, nil
+}
+
+// headResourceInfo 使用 HEAD 请求探测资源，不获取文件类型，只尝试一次
+func (r *request) headResourceInfo() (*ResourceInfo, error) {
+	req, err := r.request(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+	req.Method = http.MethodHead
+	r.conditional(req)
+	res, err := r.timeoutDo(req)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotModified {
+		return newResourceInfo(r.uri, res), nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s HTTP Status Code %d", r.uri, res.StatusCode)
+	}
+	b := newResourceInfo(r.uri, res)
+	b.Filesize = res.ContentLength
+	b.Multithread = b.Filesize > 0 && res.Header.Get("accept-ranges") == "bytes"
+	return b, nil
+}
 
-	contentRange := res.Header.Get("content-range")
-	contentLength := res.Header.Get("content-length")
-	acceptRanges := res.Header.Get("accept-ranges")
+// rangeResourceInfo 使用 range 请求探测资源
+// 206 表示支持 range 请求，200 表示服务器忽略了 range，416 表示空文件
+func (r *request) rangeResourceInfo() (*ResourceInfo, error) {
+	req, err := r.request(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("range", fmt.Sprintf("bytes=0-%d", PROBE_SNIFF_SIZE-1))
+	r.conditional(req)
+	res, err := r.do(req, http.StatusRequestedRangeNotSatisfiable)
+	if err != nil {
+		return nil, err
+	}
+	// 未读取完的 Body 关闭时会直接断开连接，不会读取完整的资源
+	defer res.Body.Close()
 
-	b := &resourceInfo{}
-	b.etag = res.Header.Get("etag")
-	b.contentDisposition = res.Header.Get("content-disposition")
-	b.uri = r.uri
+	b := newResourceInfo(r.uri, res)
+	switch res.StatusCode {
+	case http.StatusNotModified:
+		// 条件请求时资源没有变化
+		return b, nil
+	case http.StatusPartialContent:
+		// 大小未知时只能单协程下载
+		b.Filesize = parseContentRangeSize(res.Header.Get("content-range"))
+		b.Multithread = b.Filesize > 0
+	case http.StatusRequestedRangeNotSatisfiable:
+		// 资源为空时无法满足任何 range
+		b.Filesize = parseContentRangeSize(res.Header.Get("content-range"))
+		if b.Filesize != 0 {
+			return nil, fmt.Errorf("%s HTTP Status Code %d", r.uri, res.StatusCode)
+		}
+		return b, nil
+	default:
+		b.Filesize = res.ContentLength
+	}
 
-	// 获取文件类型
-	var data []byte
-	data, err = io.ReadAll(res.Body)
+	// 获取文件类型，只读取需要的字节数
+	data, err := io.ReadAll(io.LimitReader(res.Body, PROBE_SNIFF_SIZE))
 	if err != nil {
 		return nil, err
 	}
 	kind, _ := filetype.Match(data)
 	if kind != filetype.Unknown {
-		b.extension = kind.Extension
+		b.Extension = kind.Extension
 	}
+	return b, nil
+}
 
-	// 获取文件总大小
-	rangeList := strings.Split(contentRange, "/")
-	if len(rangeList) > 1 {
-		b.filesize, _ = strconv.ParseInt(rangeList[1], 10, 64)
+// conditional 设置探测资源时的条件请求头
+func (r *request) conditional(req *http.Request) {
+	if r.ifNoneMatch != "" {
+		req.Header.Set("if-none-match", r.ifNoneMatch)
 	}
+	if r.ifModifiedSince != "" {
+		req.Header.Set("if-modified-since", r.ifModifiedSince)
+	}
+}
 
-	// 是否可以使用多协程
-	if acceptRanges != "" || strings.Contains(contentRange, "bytes") || contentLength == "262" {
-		b.multithread = true
-	} else {
-		// 不支持多协程重新获取文件总大小
-		if b.filesize == 0 {
-			b.filesize, _ = strconv.ParseInt(contentLength, 10, 64)
-		}
+// newResourceInfo 从响应中获取资源的公共信息
+func newResourceInfo(uri string, res *http.Response) *ResourceInfo {
+	b := &ResourceInfo{
+		URI:                uri,
+		StatusCode:         res.StatusCode,
+		Filesize:           -1,
+		ContentDisposition: res.Header.Get("content-disposition"),
+		Etag:               res.Header.Get("etag"),
+		LastModified:       res.Header.Get("last-modified"),
+		ContentType:        res.Header.Get("content-type"),
+		FinalURI:           uri,
 	}
-	return b, nil
+	if res.Request != nil && res.Request.URL != nil {
+		b.FinalURI = res.Request.URL.String()
+	}
+	return b
+}
+
+// ifRangeValue 获取 If-Range 的值，优先使用强 etag，弱 etag 不能用于 If-Range
+func ifRangeValue(etag, lastModified string) string {
+	if etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return lastModified
+}
+
+// parseContentRangeSize 获取 content-range 中的资源总大小，未知时返回 -1
+func parseContentRangeSize(contentRange string) int64 {
+	idx := strings.LastIndex(contentRange, "/")
+	if idx < 0 {
+		return -1
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(contentRange[idx+1:]), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
 }
 
 // rangeDo 根据参数发送带有 range 头信息的请求
-func (r *request) rangeDo(start, end int64) (*http.Response, error) {
-	req, err := r.request()
+func (r *request) rangeDo(ctx context.Context, start, end int64) (*http.Response, error) {
+	req, err := r.blockRequest(ctx)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("range", fmt.Sprintf("bytes=%d-%d", start, end))
+	// end 小于 0 时请求从 start 到资源结束的全部内容
+	if end < 0 {
+		req.Header.Set("range", fmt.Sprintf("bytes=%d-", start))
+	} else {
+		req.Header.Set("range", fmt.Sprintf("bytes=%d-%d", start, end))
+	}
+	if r.ifRange != "" {
+		req.Header.Set("if-range", r.ifRange)
+	}
+	if r.beforeRequest != nil {
+		err = r.beforeRequest(req)
+		if err != nil {
+			return nil, err
+		}
+	}
 	res, err := r.do(req)
 	if err != nil {
 		return nil, err
@@ -136,11 +258,20 @@ func (r *request) rangeDo(start, end int64) (*http.Response, error) {
 }
 
 // defaultDo 根据参数发送请求
-func (r *request) defaultDo() (*http.Response, error) {
-	req, err := r.request()
+func (r *request) defaultDo(ctx context.Context) (*http.Response, error) {
+	req, err := r.blockRequest(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if r.acceptEncoding != "" {
+		req.Header.Set("accept-encoding", r.acceptEncoding)
+	}
+	if r.beforeRequest != nil {
+		err = r.beforeRequest(req)
+		if err != nil {
+			return nil, err
+		}
+	}
 	res, err := r.do(req)
 	if err != nil {
 		return nil, err
@@ -149,7 +280,28 @@ func (r *request) defaultDo() (*http.Response, error) {
 }
 
 // request 根据参数生产请求，拷贝 header 信息
-func (r *request) request() (*http.Request, error) {
+func (r *request) request(ctx context.Context) (*http.Request, error) {
+	return r.newRequest(ctx, r.uri)
+}
+
+// blockRequest 生产下载块的请求，探测资源时发生了跳转时直接请求跳转后的链接
+// 跳转后的链接不是同一个主机时不携带认证信息和 cookie
+func (r *request) blockRequest(ctx context.Context) (*http.Request, error) {
+	if r.finalURI == "" {
+		return r.newRequest(ctx, r.uri)
+	}
+	req, err := r.newRequest(ctx, r.finalURI)
+	if err != nil {
+		return nil, err
+	}
+	if u, err := url.Parse(r.uri); err != nil || !strings.EqualFold(u.Host, req.URL.Host) {
+		delSensitiveHeader(req.Header)
+	}
+	return req, nil
+}
+
+// newRequest 使用指定的链接生产请求
+func (r *request) newRequest(ctx context.Context, uri string) (*http.Request, error) {
 	// body reader 重复读
 	if r.body != nil {
 		v, ok := r.body.(*MultiReadable)
@@ -157,22 +309,30 @@ func (r *request) request() (*http.Request, error) {
 			v.Reset()
 		}
 	}
-	req, err := http.NewRequestWithContext(r.ctx, r.method, r.uri, r.body)
+	req, err := http.NewRequestWithContext(ctx, r.method, uri, r.body)
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header = r.header.Clone()
 
+	err = r.sign(req, false)
+	if err != nil {
+		return nil, err
+	}
+
 	return req, nil
 }
 
 // do 对于 client.Do 的包装，主要实现重试机制
-func (r *request) do(rsequest *http.Request) (*http.Response, error) {
+// acceptStatus 中的状态码即使大于等于 400 也视为成功
+func (r *request) do(rsequest *http.Request, acceptStatus ...int) (*http.Response, error) {
 	var (
 		res          *http.Response
 		requestError error
 		retryNum     = 0
+		refreshed    = false
+		fallback     = false
 	)
 
 	r.logf("request header:")
@@ -182,14 +342,56 @@ func (r *request) do(rsequest *http.Request) (*http.Response, error) {
 		}
 	}
 	for ; ; retryNum++ {
-		res, requestError = r.client.Do(rsequest)
+		res, requestError = r.timeoutDo(rsequest)
 		r.log("request: do retry num", retryNum)
 		if requestError != nil {
 			r.log("request: error", requestError)
 		}
-		if requestError == nil && res.StatusCode < 400 {
+		if requestError == nil && (res.StatusCode < 400 || statusIn(res.StatusCode, acceptStatus)) {
 			break
-		} else if retryNum+1 >= r.retryNumber {
+		}
+		if res != nil {
+			res.Body.Close()
+		}
+		// 跳转被拒绝或者次数过多时重试的结果相同，直接返回
+		if errors.Is(requestError, ErrRedirectDenied) || errors.Is(requestError, ErrTooManyRedirects) {
+			return nil, requestError
+		}
+		// 跳转后的链接失效或者无法请求时，使用原始链接重新跳转，立即重新请求一次
+		// 续传时跳转后的链接来自断点文件，可能已经失效很久
+		expired := requestError != nil && !contextDone(rsequest.Context()) ||
+			requestError == nil && statusIn(res.StatusCode, []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusGone})
+		if expired && !fallback && r.finalURI != "" && rsequest.URL.String() == r.finalURI {
+			fallback = true
+			if requestError != nil {
+				r.log("request: final uri failed", requestError)
+			} else {
+				r.log("request: final uri expired, status", res.StatusCode)
+			}
+			// 重新生产原始链接的请求，补全跳转到其他主机时删除的认证信息
+			if origin, err := r.newRequest(rsequest.Context(), r.uri); err == nil {
+				rsequest.URL, rsequest.Host = origin.URL, origin.Host
+				for key := range origin.Header {
+					if rsequest.Header.Get(key) == "" {
+						rsequest.Header.Set(key, origin.Header.Get(key))
+					}
+				}
+				retryNum--
+				continue
+			}
+		}
+		// 签名或者链接过期时重新签名，立即重新请求一次
+		if requestError == nil && len(r.signers) > 0 && !refreshed && statusIn(res.StatusCode, []int{http.StatusUnauthorized, http.StatusForbidden}) {
+			refreshed = true
+			r.log("request: refresh sign, status", res.StatusCode)
+			requestError = r.sign(rsequest, true)
+			if requestError != nil {
+				return nil, requestError
+			}
+			retryNum--
+			continue
+		}
+		if retryNum+1 >= r.retryNumber || contextDone(rsequest.Context()) {
 			var err error
 			if requestError != nil {
 				err = requestError
@@ -203,6 +405,82 @@ func (r *request) do(rsequest *http.Request) (*http.Response, error) {
 	return res, nil
 }
 
+// statusIn 状态码是否在列表中
+func statusIn(code int, list []int) bool {
+	for _, v := range list {
+		if code == v {
+			return true
+		}
+	}
+	return false
+}
+
+// timeoutDo 发送单次请求，分别限制建立连接和等待响应头的时间
+// 响应成功后 Body 关闭时释放上下文
+func (r *request) timeoutDo(rsequest *http.Request) (*http.Response, error) {
+	if r.connectTimeout <= 0 && r.responseHeaderTimeout <= 0 {
+		return r.client.Do(rsequest)
+	}
+	ctx, cancel := context.WithCancel(rsequest.Context())
+
+	var (
+		mux     sync.Mutex
+		timer   *time.Timer
+		timeout error
+	)
+	// startTimer 开始一个阶段的计时，超时后取消请求
+	startTimer := func(d time.Duration, err error) {
+		mux.Lock()
+		defer mux.Unlock()
+		if timer != nil {
+			timer.Stop()
+			timer = nil
+		}
+		if d <= 0 {
+			return
+		}
+		timer = time.AfterFunc(d, func() {
+			mux.Lock()
+			timeout = fmt.Errorf("%w: %s", err, d)
+			mux.Unlock()
+			cancel()
+		})
+	}
+	startTimer(r.connectTimeout, ErrConnectTimeout)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			startTimer(r.responseHeaderTimeout, ErrResponseHeaderTimeout)
+		},
+	}
+
+	res, err := r.client.Do(rsequest.WithContext(httptrace.WithClientTrace(ctx, trace)))
+	startTimer(0, nil)
+	if err != nil {
+		cancel()
+		mux.Lock()
+		defer mux.Unlock()
+		if timeout != nil {
+			return nil, timeout
+		}
+		return nil, err
+	}
+	res.Body = &cancelReadCloser{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// cancelReadCloser 关闭时同时取消上下文
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close 关闭并取消上下文
+func (c *cancelReadCloser) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
 // log 打印调试信息
 func (r *request) log(v ...interface{}) {
 	if r.debug {
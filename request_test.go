@@ -1,36 +1,113 @@
 package rain
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
 
 // TestAutoFilename 测试自动获取文件名称
 func TestAutoFilename(t *testing.T) {
-	data := &resourceInfo{
-		uri:                "",
-		contentDisposition: "",
-		extension:          "",
+	data := &ResourceInfo{
+		URI:                "",
+		ContentDisposition: "",
+		Extension:          "",
 	}
 
 	if data.getFilename() != "" {
 		t.Fatal()
 	}
-	data.uri = "http://www.68wu.cn/"
+	data.URI = "http://www.68wu.cn/"
 	if data.getFilename() != "" {
 		t.Fatal()
 	}
-	data.uri = "http://www.68wu.cn/hello"
+	data.URI = "http://www.68wu.cn/hello"
 	if data.getFilename() != "hello" {
 		t.Fatal()
 	}
-	data.uri = "http://www.68wu.cn/hello?s=x&x=s"
+	data.URI = "http://www.68wu.cn/hello?s=x&x=s"
 	if data.getFilename() != "hello" {
 		t.Fatal()
 	}
-	data.extension = "jpg"
+	data.Extension = "jpg"
 	if data.getFilename() != "hello.jpg" {
 		t.Fatal()
 	}
-	data.contentDisposition = "attachment;filename=file.xml"
+	data.ContentDisposition = "attachment;filename=file.xml"
 	if data.getFilename() != "file.xml" {
 		t.Fatal()
 	}
 }
+
+// newTestRequest 创建测试用的请求器
+func newTestRequest(uri string) *request {
+	return &request{
+		ctx:         context.Background(),
+		uri:         uri,
+		client:      http.DefaultClient,
+		method:      http.MethodGet,
+		header:      http.Header{},
+		retryNumber: 1,
+	}
+}
+
+// TestGetResourceInfo 测试探测资源信息
+func TestGetResourceInfo(t *testing.T) {
+	data := bytes.Repeat([]byte("rain"), 1024)
+	png := append([]byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A}, data...)
+
+	servers := map[string]http.HandlerFunc{
+		// 支持 range 请求
+		"range": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("etag", `"rain"`)
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(png))
+		},
+		// 忽略 range 请求，返回完整内容
+		"norange": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("content-length", fmt.Sprint(len(png)))
+			w.Write(png)
+		},
+		// 空文件
+		"empty": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("content-range", "bytes */0")
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		},
+		// 不支持 HEAD 请求
+		"nohead": func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(png))
+		},
+	}
+	testData := []struct {
+		server      string
+		head        bool
+		statusCode  int
+		filesize    int64
+		multithread bool
+		extension   string
+	}{
+		{"range", false, http.StatusPartialContent, int64(len(png)), true, "png"},
+		{"range", true, http.StatusOK, int64(len(png)), true, ""},
+		{"norange", false, http.StatusOK, int64(len(png)), false, "png"},
+		{"empty", false, http.StatusRequestedRangeNotSatisfiable, 0, false, ""},
+		{"nohead", true, http.StatusPartialContent, int64(len(png)), true, "png"},
+	}
+	for key, val := range testData {
+		server := httptest.NewServer(servers[val.server])
+		info, err := newTestRequest(server.URL).getResourceInfo(val.head)
+		server.Close()
+		if err != nil {
+			t.Fatal(key, err)
+		}
+		if info.StatusCode != val.statusCode || info.Filesize != val.filesize || info.Multithread != val.multithread || info.Extension != val.extension {
+			t.Errorf("%d 探测信息错误: %#v", key, info)
+		}
+	}
+}
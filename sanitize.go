@@ -0,0 +1,137 @@
+package rain
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// FilenamePolicy 文件名非法字符的过滤规则
+type FilenamePolicy int
+
+const (
+	// FILENAME_LEGACY 删除 ?\/*"<>|: 和开头的空格，截取前 255 个字符
+	FILENAME_LEGACY = FilenamePolicy(iota)
+	// FILENAME_POSIX 替换 / 和控制字符，文件名最长 255 字节
+	FILENAME_POSIX
+	// FILENAME_WINDOWS 替换 <>:"/\|?* 和控制字符，去掉结尾的点和空格，避开 CON、NUL 等保留名称，文件名最长 255 个 UTF-16 字符
+	FILENAME_WINDOWS
+	// FILENAME_PORTABLE 同时满足 FILENAME_POSIX 和 FILENAME_WINDOWS，文件名最长 255 字节
+	FILENAME_PORTABLE
+)
+
+// FILENAME_MAX_LENGTH 文件名的最大长度
+const FILENAME_MAX_LENGTH = 255
+
+// windowsReserved Windows 的保留名称，不区分大小写，带有扩展名时也不能使用
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizeFilename 按照过滤规则处理文件名，非法字符使用 replacement 替换
+// replacement 本身包含非法字符时直接删除非法字符，处理后为空时返回 _
+func sanitizeFilename(name string, policy FilenamePolicy, replacement string) string {
+	if policy == FILENAME_LEGACY {
+		return filterFileName(name)
+	}
+	windows := policy == FILENAME_WINDOWS || policy == FILENAME_PORTABLE
+	if strings.IndexFunc(replacement, func(r rune) bool { return illegalRune(r, windows) }) >= 0 {
+		replacement = ""
+	}
+
+	b := strings.Builder{}
+	for _, r := range name {
+		if r == utf8.RuneError || illegalRune(r, windows) {
+			b.WriteString(replacement)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	name = b.String()
+
+	if windows {
+		// Windows 会忽略结尾的点和空格
+		name = strings.TrimRight(name, ". ")
+		// 保留名称只判断第一个点之前的部分
+		stem := name
+		if i := strings.IndexByte(name, '.'); i >= 0 {
+			stem = name[:i]
+		}
+		if windowsReserved[strings.ToUpper(strings.TrimRight(stem, " "))] {
+			name = stem + "_" + name[len(stem):]
+		}
+	}
+	if name == "." || name == ".." {
+		name = strings.Repeat("_", len(name))
+	}
+
+	if policy == FILENAME_WINDOWS {
+		name = truncateFilename(name, utf16Length)
+	} else {
+		name = truncateFilename(name, func(s string) int { return len(s) })
+	}
+	if windows {
+		name = strings.TrimRight(name, ". ")
+	}
+	if name == "" {
+		return "_"
+	}
+	return name
+}
+
+// illegalRune 是否为文件名中不能使用的字符
+func illegalRune(r rune, windows bool) bool {
+	if r == '/' || r < 0x20 || r == 0x7f {
+		return true
+	}
+	return windows && strings.ContainsRune(`<>:"\|?*`, r)
+}
+
+// utf16Length 字符串的 UTF-16 长度
+func utf16Length(s string) int {
+	n := 0
+	for _, r := range s {
+		// 超出基本平面的字符使用两个 UTF-16 编码单元
+		if r > 0xffff {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// truncateFilename 将文件名截取到 FILENAME_MAX_LENGTH 以内，保留扩展名，不会截断多字节字符
+func truncateFilename(name string, length func(string) int) string {
+	if length(name) <= FILENAME_MAX_LENGTH {
+		return name
+	}
+	ext := filepath.Ext(name)
+	// 扩展名过长时不保留
+	if length(ext) > FILENAME_MAX_LENGTH/2 {
+		ext = ""
+	}
+	base := strings.TrimSuffix(name, ext)
+	limit := FILENAME_MAX_LENGTH - length(ext)
+	b := strings.Builder{}
+	n := 0
+	for _, r := range base {
+		size := length(string(r))
+		if n+size > limit {
+			break
+		}
+		b.WriteRune(r)
+		n += size
+	}
+	return b.String() + ext
+}
+
+// filterFilename 开启 AutoFilterFilename 时按照配置的过滤规则处理文件名
+func (ctl *control) filterFilename(name string) string {
+	if !ctl.config.AutoFilterFilename {
+		return name
+	}
+	return sanitizeFilename(name, ctl.config.FilenamePolicy, ctl.config.FilenameReplacement)
+}
@@ -0,0 +1,52 @@
+package rain
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// TestSanitizeFilename 测试文件名过滤规则
+func TestSanitizeFilename(t *testing.T) {
+	testData := []struct {
+		name        string
+		policy      FilenamePolicy
+		replacement string
+		out         string
+	}{
+		{" a:b?.txt", FILENAME_LEGACY, "_", "ab.txt"},
+		{"a:b/c\x00d.txt", FILENAME_POSIX, "_", "a:b_c_d.txt"},
+		{"..", FILENAME_POSIX, "_", "__"},
+		{`a<b>c:d"e\f|g?h*.txt`, FILENAME_WINDOWS, "_", "a_b_c_d_e_f_g_h_.txt"},
+		{"report. . ", FILENAME_WINDOWS, "_", "report"},
+		{"CON", FILENAME_WINDOWS, "_", "CON_"},
+		{"nul.tar.gz", FILENAME_WINDOWS, "_", "nul_.tar.gz"},
+		{"console.txt", FILENAME_WINDOWS, "_", "console.txt"},
+		{"a\tb:c.txt", FILENAME_PORTABLE, "-", "a-b-c.txt"},
+		{"a:b.txt", FILENAME_PORTABLE, "", "ab.txt"},
+		{"a:b.txt", FILENAME_PORTABLE, ":", "ab.txt"},
+		{"???", FILENAME_PORTABLE, "", "_"},
+	}
+	for _, v := range testData {
+		if out := sanitizeFilename(v.name, v.policy, v.replacement); out != v.out {
+			t.Errorf("%q: %q != %q", v.name, out, v.out)
+		}
+	}
+
+	// 按照字节截取，保留扩展名，不截断多字节字符
+	long := strings.Repeat("中", 100) + ".mp4"
+	for _, policy := range []FilenamePolicy{FILENAME_POSIX, FILENAME_PORTABLE} {
+		out := sanitizeFilename(long, policy, "_")
+		if len(out) > FILENAME_MAX_LENGTH || !strings.HasSuffix(out, ".mp4") || !utf8.ValidString(out) {
+			t.Errorf("%d 截取错误: %d %q", policy, len(out), out)
+		}
+	}
+	// Windows 按照 UTF-16 长度截取
+	if out := sanitizeFilename(long, FILENAME_WINDOWS, "_"); out != long {
+		t.Errorf("Windows 不需要截取: %q", out)
+	}
+	out := sanitizeFilename(strings.Repeat("😀", 200)+".txt", FILENAME_WINDOWS, "_")
+	if utf16Length(out) > FILENAME_MAX_LENGTH || !strings.HasSuffix(out, ".txt") {
+		t.Errorf("Windows 截取错误: %d", utf16Length(out))
+	}
+}
@@ -0,0 +1,127 @@
+package rain
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SpeedSchedule 分时段限速计划，按照一天中的时间自动调整下载速度限制
+type SpeedSchedule struct {
+	// mux 锁
+	mux sync.RWMutex
+	// rules 时段规则，靠前的规则优先匹配
+	rules []*speedRule
+	// defaultLimit 不在任何时段内时的限速，0 为不限速
+	defaultLimit int
+}
+
+// speedRule 时段限速规则
+type speedRule struct {
+	// start 开始时间，距离零点的时长
+	start time.Duration
+	// end 结束时间，距离零点的时长
+	end time.Duration
+	// speedLimit 时段内的限速，0 为不限速
+	speedLimit int
+}
+
+// NewSpeedSchedule 创建限速计划，defaultLimit 为不在任何时段内时的限速，0 为不限速
+func NewSpeedSchedule(defaultLimit int) *SpeedSchedule {
+	return &SpeedSchedule{
+		rules:        make([]*speedRule, 0),
+		defaultLimit: defaultLimit,
+	}
+}
+
+// Add 添加时段限速规则，时间格式为 15:04，结束时间可以为 24:00
+// start 大于 end 时表示跨越零点，start 等于 end 时表示全天
+func (s *SpeedSchedule) Add(start, end string, speedLimit int) error {
+	startOffset, err := parseClock(start)
+	if err != nil {
+		return err
+	}
+	endOffset, err := parseClock(end)
+	if err != nil {
+		return err
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.rules = append(s.rules, &speedRule{
+		start:      startOffset,
+		end:        endOffset,
+		speedLimit: speedLimit,
+	})
+	return nil
+}
+
+// Limit 获取指定时间的限速
+func (s *SpeedSchedule) Limit(t time.Time) int {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	offset := clockOffset(t)
+	for _, rule := range s.rules {
+		if rule.contains(offset) {
+			return rule.speedLimit
+		}
+	}
+	return s.defaultLimit
+}
+
+// next 获取指定时间之后最近的一次时段切换时间，没有规则时返回零值
+func (s *SpeedSchedule) next(t time.Time) time.Time {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	var next time.Time
+	for _, rule := range s.rules {
+		for _, offset := range []time.Duration{rule.start, rule.end} {
+			at := clockTime(t, offset)
+			if !at.After(t) {
+				at = clockTime(t.AddDate(0, 0, 1), offset)
+			}
+			if next.IsZero() || at.Before(next) {
+				next = at
+			}
+		}
+	}
+	return next
+}
+
+// contains 时段是否包含指定的时间
+func (rule *speedRule) contains(offset time.Duration) bool {
+	switch {
+	case rule.start < rule.end:
+		return offset >= rule.start && offset < rule.end
+	case rule.start > rule.end:
+		return offset >= rule.start || offset < rule.end
+	default:
+		return true
+	}
+}
+
+// parseClock 解析 15:04 格式的时间，返回距离零点的时长
+func parseClock(s string) (time.Duration, error) {
+	var hour, minute int
+	_, err := fmt.Sscanf(s, "%d:%d", &hour, &minute)
+	if err != nil {
+		return 0, fmt.Errorf("invalid clock %q: %w", s, err)
+	}
+	if hour < 0 || hour > 24 || minute < 0 || minute > 59 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid clock %q", s)
+	}
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute, nil
+}
+
+// clockOffset 获取时间距离当天零点的时长
+func clockOffset(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second +
+		time.Duration(t.Nanosecond())
+}
+
+// clockTime 获取时间当天零点之后 offset 的时间
+func clockTime(t time.Time, offset time.Duration) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location()).Add(offset)
+}
@@ -0,0 +1,73 @@
+package rain
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSpeedSchedule 测试分时段限速计划
+func TestSpeedSchedule(t *testing.T) {
+	schedule := NewSpeedSchedule(512 << 10)
+	if err := schedule.Add("00:00", "07:00", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := schedule.Add("09:00", "18:00", 2<<20); err != nil {
+		t.Fatal(err)
+	}
+	if err := schedule.Add("22:00", "01:00", 1<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2022, 10, 1, 0, 0, 0, 0, time.Local)
+	testData := []struct {
+		clock time.Duration
+		limit int
+		next  time.Duration
+	}{
+		{time.Hour * 3, 0, time.Hour * 7},
+		{time.Hour * 7, 512 << 10, time.Hour * 9},
+		{time.Hour*12 + time.Minute*30, 2 << 20, time.Hour * 18},
+		{time.Hour * 20, 512 << 10, time.Hour * 22},
+		{time.Hour * 23, 1 << 20, time.Hour * 24},
+	}
+	for key, val := range testData {
+		now := day.Add(val.clock)
+		if limit := schedule.Limit(now); limit != val.limit {
+			t.Errorf("%d 限速错误, 输出 %d, 应输出 %d", key, limit, val.limit)
+		}
+		if next := schedule.next(now); !next.Equal(day.Add(val.next)) {
+			t.Errorf("%d 切换时间错误, 输出 %s, 应输出 %s", key, next, day.Add(val.next))
+		}
+	}
+
+	if !NewSpeedSchedule(0).next(day).IsZero() {
+		t.Error("没有规则时不应该有切换时间")
+	}
+}
+
+// TestParseClock 测试解析时间
+func TestParseClock(t *testing.T) {
+	testData := []struct {
+		clock  string
+		offset time.Duration
+		err    bool
+	}{
+		{"00:00", 0, false},
+		{"07:30", time.Hour*7 + time.Minute*30, false},
+		{"24:00", time.Hour * 24, false},
+		{"24:01", 0, true},
+		{"12:60", 0, true},
+		{"-1:00", 0, true},
+		{"noon", 0, true},
+	}
+	for _, val := range testData {
+		offset, err := parseClock(val.clock)
+		if (err != nil) != val.err {
+			t.Errorf("%s 错误判断失败: %v", val.clock, err)
+			continue
+		}
+		if offset != val.offset {
+			t.Errorf("%s 解析失败, 输出 %s, 应输出 %s", val.clock, offset, val.offset)
+		}
+	}
+}
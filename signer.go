@@ -0,0 +1,70 @@
+package rain
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// RequestSigner 请求签名，每次发送请求之前执行，可以修改请求的链接和请求头
+// refresh 为 true 时表示请求返回了 401 或 403，需要重新签名或者获取新的链接，之后会重新发送一次请求
+// 断点只校验资源的 etag、大小和修改时间，使用新的链接也可以继续下载
+type RequestSigner interface {
+	Sign(req *http.Request, refresh bool) error
+}
+
+// RequestSignerFunc 使用函数实现 RequestSigner
+type RequestSignerFunc func(req *http.Request, refresh bool) error
+
+// Sign 请求签名
+func (f RequestSignerFunc) Sign(req *http.Request, refresh bool) error {
+	return f(req, refresh)
+}
+
+// urlRefresher 请求返回 401 或 403 时获取新的下载链接
+type urlRefresher struct {
+	mux      sync.Mutex
+	provider func(ctx context.Context) (string, error)
+	// uri 最近获取到的链接
+	uri *url.URL
+}
+
+// RefreshURL 请求返回 401 或 403 时使用 provider 获取新的下载链接，之后的请求都使用新的链接
+// 适用于 S3、OSS 等会过期的预签名链接
+func RefreshURL(provider func(ctx context.Context) (string, error)) RequestSigner {
+	return &urlRefresher{provider: provider}
+}
+
+// Sign 使用最近获取到的链接
+func (u *urlRefresher) Sign(req *http.Request, refresh bool) error {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	// 其他请求已经获取了新的链接时不需要重复获取
+	if refresh && (u.uri == nil || u.uri.String() == req.URL.String()) {
+		uri, err := u.provider(req.Context())
+		if err != nil {
+			return err
+		}
+		u.uri, err = url.Parse(uri)
+		if err != nil {
+			return err
+		}
+	}
+	if u.uri != nil {
+		uri := *u.uri
+		req.URL = &uri
+		req.Host = uri.Host
+	}
+	return nil
+}
+
+// sign 依次执行请求签名
+func (r *request) sign(req *http.Request, refresh bool) error {
+	for _, v := range r.signers {
+		if err := v.Sign(req, refresh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
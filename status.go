@@ -15,6 +15,10 @@ const (
 	STATUS_ERROR
 	// STATUS_FINISH 完成
 	STATUS_FINISH
+	// STATUS_UNCHANGED 同步模式下资源没有变化，没有下载
+	STATUS_UNCHANGED
+	// STATUS_PROCESSING 下载完成，正在执行下载后的处理步骤
+	STATUS_PROCESSING
 )
 
 // Is 列表中是否有相同值
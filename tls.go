@@ -0,0 +1,141 @@
+package rain
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// tlsSettings TLS 设置，加载证书文件的错误在开始下载时返回
+type tlsSettings struct {
+	// config 基础配置，为 nil 时使用请求客户端已有的配置
+	config *tls.Config
+	// caFiles PEM 格式的 CA 证书文件
+	caFiles []string
+	// certFile、keyFile PEM 格式的客户端证书和私钥
+	certFile string
+	keyFile  string
+	// minVersion 最低 TLS 版本
+	minVersion uint16
+	// pins 服务器证书公钥的 sha256 base64 值
+	pins []string
+	// insecure 是否跳过证书验证，nil 时不修改
+	insecure *bool
+}
+
+// apply 将设置应用到 cfg
+func (s *tlsSettings) apply(cfg *tls.Config) error {
+	for _, path := range s.caFiles {
+		err := appendCACertFile(cfg, path)
+		if err != nil {
+			return err
+		}
+	}
+	if s.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+		if err != nil {
+			return err
+		}
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+	if s.minVersion != 0 {
+		cfg.MinVersion = s.minVersion
+	}
+	if len(s.pins) > 0 {
+		cfg.VerifyConnection = verifyPins(s.pins)
+	}
+	if s.insecure != nil {
+		cfg.InsecureSkipVerify = *s.insecure
+	}
+	return nil
+}
+
+// appendCACertFile 在系统证书的基础上添加 PEM 格式的 CA 证书
+func appendCACertFile(cfg *tls.Config, path string) error {
+	d, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if cfg.RootCAs == nil {
+		cfg.RootCAs, err = x509.SystemCertPool()
+		if err != nil {
+			cfg.RootCAs = x509.NewCertPool()
+		}
+	}
+	if !cfg.RootCAs.AppendCertsFromPEM(d) {
+		return fmt.Errorf("%s: no certificates found", path)
+	}
+	return nil
+}
+
+// verifyPins 服务器证书链中至少有一个证书的公钥与 pins 匹配
+func verifyPins(pins []string) func(cs tls.ConnectionState) error {
+	set := make(map[string]bool, len(pins))
+	for _, v := range pins {
+		set[strings.TrimPrefix(v, "sha256/")] = true
+	}
+	return func(cs tls.ConnectionState) error {
+		for _, cert := range cs.PeerCertificates {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if set[base64.StdEncoding.EncodeToString(sum[:])] {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s", ErrPinMismatch, cs.ServerName)
+	}
+}
+
+// tlsSettings 获取当前下载的 TLS 设置
+func (ctl *control) tlsSettings() *tlsSettings {
+	if ctl.tls == nil {
+		ctl.tls = &tlsSettings{}
+	}
+	return ctl.tls
+}
+
+// applyTLS 将当前下载的 TLS 设置应用到复制的 transport
+func (ctl *control) applyTLS(transport *http.Transport) error {
+	if ctl.tls == nil {
+		return nil
+	}
+	cfg := ctl.tls.config
+	if cfg == nil {
+		cfg = transport.TLSClientConfig
+	}
+	if cfg == nil {
+		cfg = &tls.Config{}
+	} else {
+		cfg = cfg.Clone()
+	}
+	err := ctl.tls.apply(cfg)
+	if err != nil {
+		return err
+	}
+	transport.TLSClientConfig = cfg
+	return nil
+}
+
+// setTLS 将设置应用到默认请求客户端
+// 在复制的配置上修改后再替换，不修改调用者传入或者正在使用的配置
+func (rain *Rain) setTLS(s *tlsSettings) error {
+	transport, ok := rain.client.Transport.(*http.Transport)
+	if !ok {
+		return errors.New("tls options require *http.Transport")
+	}
+	cfg := &tls.Config{}
+	if transport.TLSClientConfig != nil {
+		cfg = transport.TLSClientConfig.Clone()
+	}
+	err := s.apply(cfg)
+	if err != nil {
+		return err
+	}
+	transport.TLSClientConfig = cfg
+	return nil
+}
@@ -6,10 +6,7 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
-	"mime"
-	"net/url"
 	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
@@ -37,30 +34,6 @@ func fileExist(path string) bool {
 	return true
 }
 
-// getFilename 获取附加的文件名称
-func getMimeFilename(s string) string {
-	_, params, err := mime.ParseMediaType(s)
-	if err != nil {
-		return ""
-	}
-	if val, ok := params["filename"]; ok {
-		return val
-	}
-	return ""
-}
-
-// getUriFilename 获取资源链接中的文件名
-func getUriFilename(s string) string {
-	u, _ := url.Parse(s)
-	if u != nil {
-		us := strings.Split(u.Path, "/")
-		if len(us) > 1 {
-			return us[len(us)-1]
-		}
-	}
-	return ""
-}
-
 // randomString 随机数
 // size 随机码的位数
 // kind 0=纯数字,1=小写字母,2=大写字母,3=数字、大小写字母
@@ -102,24 +75,6 @@ func formatFileSize(fileSize int64) string {
 	}
 }
 
-// autoFileRenaming 自动文件重命名，寻找不冲突的命名
-func autoFileRenaming(dir, name string) (string, string) {
-	i := 1
-	ext := filepath.Ext(name)
-	name = strings.TrimSuffix(name, ext)
-	var path string
-	var filename string
-	for {
-		filename = fmt.Sprintf("%s.%d%s", name, i, ext)
-		path = filepath.Join(dir, filename)
-		if !fileExist(path) {
-			break
-		}
-		i++
-	}
-	return path, filename
-}
-
 // filterFileName 过滤非法字符
 func filterFileName(name string) string {
 	// 过滤头部的空格